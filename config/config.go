@@ -6,6 +6,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -24,10 +26,18 @@ type Message struct {
 
 // Implement sql.Scanner for Message
 func (m *Message) Scan(value interface{}) error {
-	// Ensure the value is a byte slice
-	bytes, ok := value.([]byte)
-	if !ok {
-		return fmt.Errorf("failed to scan Message: expected []byte, got %T", value)
+	// Drivers return the JSON as a byte slice or, like SQLite, as a string
+	var bytes []byte
+	switch v := value.(type) {
+	case []byte:
+		bytes = v
+	case string:
+		bytes = []byte(v)
+	case nil:
+		// The message column of the original schema is nullable
+		return nil
+	default:
+		return fmt.Errorf("failed to scan Message: expected []byte or string, got %T", value)
 	}
 
 	// Unmarshal JSON into the Message struct
@@ -43,17 +53,25 @@ func (m Message) Value() (driver.Value, error) {
 type ScheduledJob struct {
 	ID                 int          `json:"id,omitempty"` // omitempty for POST requests
 	Name               string       `json:"name"`
-	NotificationType   string       `json:"notification_type"`
-	Recipient          string       `json:"recipient"`
+	NotificationType   string       `json:"notification_type"` // Channel name, or notifier type to use every channel of that type
+	Recipient          string       `json:"recipient"`         // One or more recipients separated by commas, e.g. email addresses
 	Message            Message      `json:"message"`
-	ScheduleExpression string       `json:"schedule_expression"`
+	ScheduleExpression string       `json:"schedule_expression,omitempty"`
+	Timezone           string       `json:"timezone,omitempty"`       // IANA zone the schedule expression is evaluated in, e.g. "Europe/Paris"
+	SendAt             *time.Time   `json:"send_at,omitempty"`        // Fire once at this time instead of on a schedule
+	SendAfter          string       `json:"send_after,omitempty"`     // Request only: fire once after this duration, e.g. "90m"
+	MisfirePolicy      string       `json:"misfire_policy,omitempty"` // skip (default), fire_once or fire_all
+	MisfireLimit       int          `json:"misfire_limit,omitempty"`  // Most catch-up runs sent by fire_all
+	Enabled            bool         `json:"enabled"`                  // Paused jobs are kept but not scheduled
 	LastRun            sql.NullTime `json:"last_run,omitempty"`
+	CompletedAt        *time.Time   `json:"completed_at,omitempty"` // Set once a one-shot job has fired
+	UpdatedAt          *time.Time   `json:"updated_at,omitempty"`   // Set by the store on every change to the job
 }
 
 // InstantJob struct
 type InstantJob struct {
 	NotificationType string  `json:"notification_type"`
-	Recipient        string  `json:"recipient"`
+	Recipient        string  `json:"recipient"` // One or more recipients separated by commas
 	Message          Message `json:"message"`
 }
 
@@ -63,31 +81,172 @@ type Notifier interface {
 	Notify(message *Message) error
 }
 
+// RecipientNotifier is implemented by notifiers that address each message, e.g. to email
+// addresses or phone numbers. The target set in config.yaml is only used when a delivery
+// has no recipient.
+type RecipientNotifier interface {
+	NotifyRecipients(message *Message, recipients []string) error
+}
+
+// TrackedNotifier is implemented by notifiers that read back the IDs the provider gave the
+// messages, which are recorded on the delivery so it can be traced in the provider's logs
+type TrackedNotifier interface {
+	// NotifyTracked sends like NotifyRecipients, or like Notify when there are no recipients,
+	// and returns the provider message IDs
+	NotifyTracked(message *Message, recipients []string) ([]string, error)
+}
+
+// RecipientValidator is implemented by notifiers that have requirements on recipients,
+// so that jobs with recipients they can't reach are rejected when they are created
+type RecipientValidator interface {
+	ValidateRecipients(recipients []string) error
+}
+
+// Recipients splits a recipient field, which holds one recipient or several separated by commas.
+// Recipients that contain commas themselves, such as web push subscriptions, are given as a
+// JSON object or a JSON array of strings and objects, each object being kept as compact JSON.
+func Recipients(recipient string) []string {
+	if trimmed := strings.TrimSpace(recipient); strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		if recipients, ok := jsonRecipients(trimmed); ok {
+			return recipients
+		}
+	}
+	var recipients []string
+	for _, r := range strings.Split(recipient, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			recipients = append(recipients, r)
+		}
+	}
+	return recipients
+}
+
+// jsonRecipients reads recipients written as JSON, reporting false when they aren't
+func jsonRecipients(recipient string) ([]string, bool) {
+	var values []json.RawMessage
+	if strings.HasPrefix(recipient, "{") {
+		values = []json.RawMessage{json.RawMessage(recipient)}
+	} else if err := json.Unmarshal([]byte(recipient), &values); err != nil {
+		return nil, false
+	}
+
+	var recipients []string
+	for _, value := range values {
+		var text string
+		if err := json.Unmarshal(value, &text); err == nil {
+			if text = strings.TrimSpace(text); text != "" {
+				recipients = append(recipients, text)
+			}
+			continue
+		}
+		var object map[string]interface{}
+		if err := json.Unmarshal(value, &object); err != nil {
+			return nil, false
+		}
+		compact, _ := json.Marshal(object)
+		recipients = append(recipients, string(compact))
+	}
+	return recipients, true
+}
+
+// RecipientsOrDefault returns the recipients of a delivery or, when there are none, the
+// static target of the channel if it has one
+func RecipientsOrDefault(recipients []string, fallback string) []string {
+	if len(recipients) == 0 && fallback != "" {
+		return []string{fallback}
+	}
+	return recipients
+}
+
+// MessageValidator is implemented by notifiers that have requirements on the message,
+// so that jobs can be rejected when they are created instead of failing when they run
+type MessageValidator interface {
+	ValidateMessage(message *Message) error
+}
+
+// ChannelConfig holds the settings of a channel. The core only reads the fields below,
+// every other key is kept in Settings and handed to the plugin as is.
 type ChannelConfig struct {
-	Enabled     bool   `yaml:"enabled"`
-	WebhookURL  string `yaml:"webhook_url,omitempty"`
-	URL         string `yaml:"url,omitempty"`
-	ApiKey      string `yaml:"api_key,omitempty"`
-	Host        string `yaml:"host,omitempty"`
-	Port        string `yaml:"port,omitempty"`
-	Username    string `yaml:"username,omitempty"`
-	Password    string `yaml:"password,omitempty"`
-	To          string `yaml:"to,omitempty"`
-	Device      string `yaml:"device,omitempty"`
-	ProviderAPI string `yaml:"provider_api,omitempty"`
-	PhoneNumber string `yaml:"phone_number,omitempty"`
-	ApiURL      string `yaml:"api_url,omitempty"`
-	Topic       string `yaml:"topic,omitempty"`
-	Server      string `yaml:"server,omitempty"`
+	Enabled bool `yaml:"enabled"`
+	// Type is the plugin implementing the channel, e.g. slack for an "ops-slack" channel.
+	// It defaults to the channel name. Plugin is accepted as an alias.
+	Type   string      `yaml:"type,omitempty"`
+	Plugin string      `yaml:"plugin,omitempty"`
+	Retry  RetryConfig `yaml:"retry,omitempty"`
+	// Command runs the channel as an external plugin executable instead of a Go plugin
+	Command string        `yaml:"command,omitempty"`
+	Args    []string      `yaml:"args,omitempty"`
+	Timeout time.Duration `yaml:"timeout,omitempty"` // Longest an external plugin call may take, default 30s
+
+	Settings map[string]interface{} `yaml:"-"` // Plugin specific settings, e.g. webhook_url
+}
+
+// channelKeys are the settings read by the core, which plugins don't get
+var channelKeys = []string{"enabled", "type", "plugin", "retry", "command", "args", "timeout"}
+
+// UnmarshalYAML decodes the core fields and keeps the remaining keys as plugin settings
+func (c *ChannelConfig) UnmarshalYAML(value *yaml.Node) error {
+	type plain ChannelConfig
+	if err := value.Decode((*plain)(c)); err != nil {
+		return err
+	}
+
+	settings := map[string]interface{}{}
+	if err := value.Decode(&settings); err != nil {
+		return err
+	}
+	for _, key := range channelKeys {
+		delete(settings, key)
+	}
+	c.Settings = settings
+	return nil
+}
+
+// PluginsConfig controls where channel implementations come from
+type PluginsConfig struct {
+	// Mode is builtin (linked into the binary), so (Go plugin files) or auto, the default,
+	// which uses the built-in implementation and falls back to a .so file
+	Mode string `yaml:"mode"`
+	Dir  string `yaml:"dir"` // Directory holding <channel>.so files, defaults to "plugins"
+	// HealthInterval is how often channels are health checked, 30s by default. External
+	// plugins that fail the check are restarted.
+	HealthInterval time.Duration `yaml:"health_interval"`
+}
+
+// RetryConfig controls how failed deliveries on a channel are retried
+type RetryConfig struct {
+	MaxAttempts int           `yaml:"max_attempts,omitempty"` // Total attempts including the first one
+	BaseDelay   time.Duration `yaml:"base_delay,omitempty"`   // Delay before the first retry, doubled on each attempt
+	MaxDelay    time.Duration `yaml:"max_delay,omitempty"`    // Upper bound for the delay between attempts
+	Jitter      float64       `yaml:"jitter,omitempty"`       // Random spread applied to each delay, 0.2 = ±20%
 }
 
 type Config struct {
 	Database  DatabaseConfig           `yaml:"database"`
 	Channels  map[string]ChannelConfig `yaml:"channels"`
+	Plugins   PluginsConfig            `yaml:"plugins"`
 	Scheduler bool                     `yaml:"scheduler"`
+	Delivery  DeliveryConfig           `yaml:"delivery"`
+	// InstanceID identifies this replica when several share the database, defaults to the host name
+	InstanceID string       `yaml:"instance_id"`
+	Reload     ReloadConfig `yaml:"reload"`
+	Log        LogConfig    `yaml:"log"`
+}
+
+// LogConfig controls what is logged and how
+type LogConfig struct {
+	Level  string `yaml:"level"`  // debug, info (default), warn or error
+	Format string `yaml:"format"` // text (default) or json
+}
+
+// ReloadConfig controls reloading the configuration while running
+type ReloadConfig struct {
+	Watch    bool          `yaml:"watch"`    // Reload when the file changes, SIGHUP and POST /admin/reload always work
+	Interval time.Duration `yaml:"interval"` // How often the file is checked, default 5s
 }
 
 type DatabaseConfig struct {
+	Driver   string `yaml:"driver"` // mysql (default), postgres, sqlite or memory
+	Path     string `yaml:"path"`   // Database file for sqlite
 	User     string `yaml:"user"`
 	Password string `yaml:"password"`
 	Host     string `yaml:"host"`
@@ -95,15 +254,47 @@ type DatabaseConfig struct {
 	Name     string `yaml:"name"`
 }
 
+// DeliveryConfig controls the asynchronous delivery queue
+type DeliveryConfig struct {
+	Workers      int           `yaml:"workers"`       // Number of concurrent delivery workers
+	PollInterval time.Duration `yaml:"poll_interval"` // How often idle workers check the queue
+	// ProcessingTimeout is how long a delivery may stay claimed before another replica takes it over
+	ProcessingTimeout time.Duration `yaml:"processing_timeout"`
+}
+
+// LoadConfig reads the configuration file. Environment variables named in EnvOverrides
+// take precedence over the file, and values can reference ${VAR}, ${VAR:-default} or
+// file:/path/to/secret.
 func LoadConfig(path string) (*Config, error) {
 	file, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(file, &root); err != nil {
+		return nil, err
+	}
+	if len(root.Content) == 0 {
+		// Empty file, the settings may all come from the environment
+		root = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	if root.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s: expected a mapping at the top level", path)
+	}
+	applyEnvOverrides(root.Content[0])
+	if err := interpolateConfig(&root); err != nil {
+		return nil, err
+	}
+
 	var cfg Config
-	err = yaml.Unmarshal(file, &cfg)
-	if err != nil {
+	if err := root.Decode(&cfg); err != nil {
 		return nil, err
 	}
+	if cfg.InstanceID == "" {
+		cfg.InstanceID, err = os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("resolving instance_id: %w", err)
+		}
+	}
 	return &cfg, nil
 }
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Delivery represents a notification queued for asynchronous sending
+type Delivery struct {
+	ID               int64      `json:"id"`
+	JobID            *int       `json:"job_id,omitempty"` // Set when the delivery comes from a scheduled job
+	NotificationType string     `json:"notification_type"`
+	Channel          string     `json:"channel,omitempty"` // Channel that handled the last attempt
+	Recipient        string     `json:"recipient"`
+	Message          Message    `json:"message"`
+	CatchUp          bool       `json:"catch_up,omitempty"`      // Replays a run missed while the service was down
+	ScheduledFor     *time.Time `json:"scheduled_for,omitempty"` // Fire time a catch-up run replaces
+	PayloadHash      string     `json:"payload_hash"`            // SHA-256 of the JSON encoded message
+	Status           string     `json:"status"`
+	Attempts         int        `json:"attempts"`
+	ResponseCode     *int       `json:"response_code,omitempty"` // Provider status code of the last failed attempt
+	LastError        string     `json:"last_error,omitempty"`
+	// ProviderMessageID is the ID the provider gave the sent message, comma separated when there are several
+	ProviderMessageID string     `json:"provider_message_id,omitempty"`
+	Completed         Targets    `json:"completed,omitempty"` // Channels and recipients already notified, retries skip them
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+	LastAttemptAt     *time.Time `json:"last_attempt_at,omitempty"`
+	SentAt            *time.Time `json:"sent_at,omitempty"`
+}
+
+// DeadLetter is a delivery that failed on every attempt
+type DeadLetter struct {
+	ID               int64     `json:"id"`
+	DeliveryID       int64     `json:"delivery_id"`
+	JobID            *int      `json:"job_id,omitempty"`
+	NotificationType string    `json:"notification_type"`
+	Recipient        string    `json:"recipient"`
+	Message          Message   `json:"message"`
+	Attempts         int       `json:"attempts"`
+	LastError        string    `json:"last_error"`
+	Completed        Targets   `json:"completed,omitempty"` // Notified before the delivery failed, a replay skips them
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// Target is a recipient notified through a channel
+type Target struct {
+	Channel   string `json:"channel"`
+	Recipient string `json:"recipient,omitempty"` // Empty when the channel used its configured target
+	Rejected  string `json:"rejected,omitempty"`  // Why the provider rejected the recipient, which won't be retried
+}
+
+// Targets is stored as JSON
+type Targets []Target
+
+// Contains reports whether the recipient was already handled through the channel
+func (t Targets) Contains(channel, recipient string) bool {
+	for _, target := range t {
+		if target.Channel == channel && target.Recipient == recipient {
+			return true
+		}
+	}
+	return false
+}
+
+// Scan implements sql.Scanner, NULL being no target
+func (t *Targets) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*t = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, t)
+	case string:
+		return json.Unmarshal([]byte(v), t)
+	default:
+		return fmt.Errorf("failed to scan Targets: expected []byte or string, got %T", value)
+	}
+}
+
+// Value implements driver.Valuer, storing no target as NULL
+func (t Targets) Value() (driver.Value, error) {
+	if len(t) == 0 {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	return string(encoded), nil
+}
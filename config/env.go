@@ -0,0 +1,238 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix starts the environment variables overriding top-level settings, e.g.
+// NS_DATABASE_PASSWORD for database.password or NS_SCHEDULER for scheduler
+const EnvPrefix = "NS_"
+
+// filePrefix marks a value read from a file, e.g. file:/run/secrets/db_password
+const filePrefix = "file:"
+
+// EnvOverrides returns the environment variable overriding each top-level setting, keyed
+// by the setting's path in config.yaml, e.g. "database.host": "NS_DATABASE_HOST".
+// Channels are left out, their settings can reference variables with ${VAR} instead.
+func EnvOverrides() map[string]string {
+	overrides := map[string]string{}
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		key := yamlKey(t.Field(i))
+		if key == "" || key == "channels" {
+			continue
+		}
+		field := t.Field(i).Type
+		if field.Kind() != reflect.Struct {
+			overrides[key] = envName(key)
+			continue
+		}
+		for j := 0; j < field.NumField(); j++ {
+			if sub := yamlKey(field.Field(j)); sub != "" {
+				overrides[key+"."+sub] = envName(key + "." + sub)
+			}
+		}
+	}
+	return overrides
+}
+
+func yamlKey(field reflect.StructField) string {
+	key, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if key == "-" {
+		return ""
+	}
+	return key
+}
+
+func envName(path string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(path, ".", "_"))
+}
+
+// applyEnvOverrides replaces the settings that have their environment variable set
+func applyEnvOverrides(doc *yaml.Node) {
+	for path, name := range EnvOverrides() {
+		if value, ok := os.LookupEnv(name); ok {
+			setPath(doc, strings.Split(path, "."), value)
+		}
+	}
+}
+
+// setPath sets a scalar in a mapping node, adding the keys that are missing
+func setPath(node *yaml.Node, path []string, value string) {
+	for i := 0; i < len(node.Content)-1; i += 2 {
+		if node.Content[i].Value != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			node.Content[i+1] = &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+			return
+		}
+		if node.Content[i+1].Kind != yaml.MappingNode {
+			node.Content[i+1] = &yaml.Node{Kind: yaml.MappingNode}
+		}
+		setPath(node.Content[i+1], path[1:], value)
+		return
+	}
+
+	key := &yaml.Node{Kind: yaml.ScalarNode, Value: path[0]}
+	if len(path) == 1 {
+		node.Content = append(node.Content, key, &yaml.Node{Kind: yaml.ScalarNode, Value: value})
+		return
+	}
+	child := &yaml.Node{Kind: yaml.MappingNode}
+	node.Content = append(node.Content, key, child)
+	setPath(child, path[1:], value)
+}
+
+// interpolateConfig interpolates a config document, leaving the settings of disabled
+// channels as written so that their variables and secret files need not exist
+func interpolateConfig(doc *yaml.Node) error {
+	top := doc.Content[0]
+	for i := 1; i < len(top.Content); i += 2 {
+		value := top.Content[i]
+		if top.Content[i-1].Value != "channels" || value.Kind != yaml.MappingNode {
+			if err := interpolate(value); err != nil {
+				return err
+			}
+			continue
+		}
+		for j := 1; j < len(value.Content); j += 2 {
+			channel := value.Content[j]
+			enabled, err := channelEnabled(channel)
+			if err != nil {
+				return err
+			}
+			if !enabled {
+				continue
+			}
+			if err := interpolate(channel); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// channelEnabled interpolates the enabled setting of a channel and reports its value
+func channelEnabled(channel *yaml.Node) (bool, error) {
+	if channel.Kind != yaml.MappingNode {
+		return false, nil
+	}
+	for i := 0; i < len(channel.Content)-1; i += 2 {
+		if channel.Content[i].Value != "enabled" {
+			continue
+		}
+		node := channel.Content[i+1]
+		if err := interpolate(node); err != nil {
+			return false, err
+		}
+		var enabled bool
+		if err := node.Decode(&enabled); err != nil {
+			return false, fmt.Errorf("line %d: enabled: %w", node.Line, err)
+		}
+		return enabled, nil
+	}
+	return false, nil
+}
+
+// interpolate expands ${VAR} and ${VAR:-default} in every value and replaces file: values
+// with the content of the file. Unquoted values are typed after expansion, so port: ${PORT}
+// is a number while quoted values always stay strings.
+func interpolate(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			if err := interpolate(child); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		// Only values are expanded, keys are left as written
+		for i := 1; i < len(node.Content); i += 2 {
+			if err := interpolate(node.Content[i]); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		value, err := expandValue(node.Value)
+		if err != nil {
+			if node.Line > 0 {
+				return fmt.Errorf("line %d: %w", node.Line, err)
+			}
+			return err
+		}
+		if value != node.Value {
+			node.Value = value
+			if node.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+				node.Tag = ""
+			}
+		}
+	}
+	return nil
+}
+
+// expandValue expands the variables in a value, then reads it from a file when it is a
+// file: reference
+func expandValue(value string) (string, error) {
+	value, err := expandEnv(value)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(value, filePrefix) {
+		return value, nil
+	}
+	name := strings.TrimPrefix(value, filePrefix)
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return "", fmt.Errorf("reading secret file: %w", err)
+	}
+	// Secret files usually end with a newline that isn't part of the secret
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// expandEnv replaces ${VAR} with the variable, which must be set, and ${VAR:-default} with
+// the variable or the default when it is unset or empty. $${ is a literal ${.
+func expandEnv(value string) (string, error) {
+	if !strings.Contains(value, "${") {
+		return value, nil
+	}
+
+	var b strings.Builder
+	for {
+		start := strings.Index(value, "${")
+		if start < 0 {
+			b.WriteString(value)
+			return b.String(), nil
+		}
+		if start > 0 && value[start-1] == '$' {
+			b.WriteString(value[:start])
+			b.WriteString("{")
+			value = value[start+2:]
+			continue
+		}
+		end := strings.IndexByte(value[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated ${ in %q", value)
+		}
+		b.WriteString(value[:start])
+
+		name, def, hasDefault := strings.Cut(value[start+2:start+end], ":-")
+		if name == "" {
+			return "", fmt.Errorf("empty variable name in %q", value)
+		}
+		v, ok := os.LookupEnv(name)
+		switch {
+		case hasDefault && v == "":
+			v = def
+		case !ok:
+			return "", fmt.Errorf("environment variable %s is not set, use ${%s:-default} to make it optional", name, name)
+		}
+		b.WriteString(v)
+		value = value[start+end+1:]
+	}
+}
@@ -0,0 +1,203 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("NS_TEST_SET", "value")
+	t.Setenv("NS_TEST_EMPTY", "")
+	os.Unsetenv("NS_TEST_UNSET")
+
+	tests := []struct {
+		value   string
+		want    string
+		wantErr string
+	}{
+		{"plain", "plain", ""},
+		{"${NS_TEST_SET}", "value", ""},
+		{"pre-${NS_TEST_SET}-${NS_TEST_SET}-post", "pre-value-value-post", ""},
+		{"${NS_TEST_SET:-default}", "value", ""},
+		// Set but empty is a value of its own, unless there is a default
+		{"${NS_TEST_EMPTY}", "", ""},
+		{"${NS_TEST_EMPTY:-default}", "default", ""},
+		{"${NS_TEST_UNSET:-default}", "default", ""},
+		{"${NS_TEST_UNSET:-}", "", ""},
+		{"${NS_TEST_UNSET}", "", "NS_TEST_UNSET is not set"},
+		// $${ is a literal ${
+		{"$${NS_TEST_SET}", "${NS_TEST_SET}", ""},
+		{"a$${NS_TEST_UNSET}b ${NS_TEST_SET}", "a${NS_TEST_UNSET}b value", ""},
+		{"cost: $5", "cost: $5", ""},
+		{"${NS_TEST_SET", "", "unterminated ${"},
+		{"${NS_TEST_SET} ${oops", "", "unterminated ${"},
+		{"${}", "", "empty variable name"},
+	}
+	for _, tt := range tests {
+		got, err := expandEnv(tt.value)
+		switch {
+		case tt.wantErr != "":
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expandEnv(%q) = %q, %v, want error %q", tt.value, got, err, tt.wantErr)
+			}
+		case err != nil:
+			t.Errorf("expandEnv(%q): %v", tt.value, err)
+		case got != tt.want:
+			t.Errorf("expandEnv(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestExpandValueFile(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"secret": "s3cret\n", "crlf": "s3cret\r\n", "multiline": "line 1\nline 2\n\n"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("NS_TEST_SECRETS", dir)
+
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"file:" + filepath.Join(dir, "secret"), "s3cret"},
+		{"file:" + filepath.Join(dir, "crlf"), "s3cret"},
+		{"file:" + filepath.Join(dir, "multiline"), "line 1\nline 2"},
+		// The path can come from the environment
+		{"file:${NS_TEST_SECRETS}/secret", "s3cret"},
+		// Only a value starting with file: is read
+		{"see file:" + filepath.Join(dir, "secret"), "see file:" + filepath.Join(dir, "secret")},
+	}
+	for _, tt := range tests {
+		got, err := expandValue(tt.value)
+		if err != nil || got != tt.want {
+			t.Errorf("expandValue(%q) = %q, %v, want %q", tt.value, got, err, tt.want)
+		}
+	}
+	if _, err := expandValue("file:" + filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("expandValue of a missing file succeeded")
+	}
+}
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigInterpolation(t *testing.T) {
+	t.Setenv("NS_TEST_PORT", "5433")
+	t.Setenv("NS_TEST_WEBHOOK", "https://hooks.example.com/T000")
+	os.Unsetenv("NS_TEST_UNSET")
+
+	path := writeConfig(t, `
+database:
+  port: ${NS_TEST_PORT}
+  password: "${NS_TEST_PORT}"
+  name: ${NS_TEST_UNSET:-notifications}
+scheduler: ${NS_TEST_UNSET:-true}
+reload:
+  interval: ${NS_TEST_UNSET:-10s}
+channels:
+  slack:
+    enabled: ${NS_TEST_UNSET:-true}
+    webhook_url: ${NS_TEST_WEBHOOK}
+  push:
+    enabled: false
+    service_account: file:/nonexistent/firebase.json
+    key: ${NS_TEST_UNSET}
+`)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	// Unquoted values are typed after expansion, quoted ones stay strings
+	if cfg.Database.Port != 5433 || cfg.Database.Password != "5433" || cfg.Database.Name != "notifications" {
+		t.Errorf("database = %+v", cfg.Database)
+	}
+	if !cfg.Scheduler || cfg.Reload.Interval != 10*time.Second {
+		t.Errorf("scheduler = %v, reload = %+v", cfg.Scheduler, cfg.Reload)
+	}
+	if slack := cfg.Channels["slack"]; !slack.Enabled || slack.Settings["webhook_url"] != "https://hooks.example.com/T000" {
+		t.Errorf("slack = %+v", slack)
+	}
+	// Disabled channels are left as written
+	if push := cfg.Channels["push"]; push.Settings["service_account"] != "file:/nonexistent/firebase.json" || push.Settings["key"] != "${NS_TEST_UNSET}" {
+		t.Errorf("push = %+v", push)
+	}
+
+	// Once enabled, their references must resolve
+	path = writeConfig(t, `
+channels:
+  push:
+    enabled: true
+    key: ${NS_TEST_UNSET}
+`)
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "NS_TEST_UNSET is not set") {
+		t.Errorf("LoadConfig with an unset variable = %v", err)
+	}
+}
+
+func TestLoadConfigEnvOverrides(t *testing.T) {
+	t.Setenv("NS_TEST_HOST", "db.internal")
+	t.Setenv("NS_DATABASE_PORT", "3307")
+	t.Setenv("NS_DATABASE_HOST", "${NS_TEST_HOST}")
+	t.Setenv("NS_SCHEDULER", "false")
+	t.Setenv("NS_DELIVERY_WORKERS", "8")
+	t.Setenv("NS_DELIVERY_POLL_INTERVAL", "250ms")
+	t.Setenv("NS_INSTANCE_ID", "replica-2")
+
+	path := writeConfig(t, `
+database:
+  driver: mysql
+  port: 3306
+scheduler: true
+`)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	// Overrides replace the file's values, are expanded, and are typed like unquoted values
+	if cfg.Database.Driver != "mysql" || cfg.Database.Port != 3307 || cfg.Database.Host != "db.internal" || cfg.Scheduler {
+		t.Errorf("database = %+v, scheduler = %v", cfg.Database, cfg.Scheduler)
+	}
+	// and create the keys missing from the file
+	if cfg.Delivery.Workers != 8 || cfg.Delivery.PollInterval != 250*time.Millisecond || cfg.InstanceID != "replica-2" {
+		t.Errorf("delivery = %+v, instance_id = %q", cfg.Delivery, cfg.InstanceID)
+	}
+
+	// An empty file takes every setting from the environment
+	cfg, err = LoadConfig(writeConfig(t, ""))
+	if err != nil {
+		t.Fatalf("LoadConfig of an empty file: %v", err)
+	}
+	if cfg.Database.Port != 3307 || cfg.Delivery.Workers != 8 {
+		t.Errorf("database = %+v, delivery = %+v", cfg.Database, cfg.Delivery)
+	}
+}
+
+func TestEnvOverrides(t *testing.T) {
+	overrides := EnvOverrides()
+	for path, name := range map[string]string{
+		"database.password":      "NS_DATABASE_PASSWORD",
+		"delivery.poll_interval": "NS_DELIVERY_POLL_INTERVAL",
+		"scheduler":              "NS_SCHEDULER",
+		"instance_id":            "NS_INSTANCE_ID",
+	} {
+		if overrides[path] != name {
+			t.Errorf("override of %s = %q, want %s", path, overrides[path], name)
+		}
+	}
+	for path := range overrides {
+		if strings.HasPrefix(path, "channels") {
+			t.Errorf("channel setting %s has an override", path)
+		}
+	}
+}
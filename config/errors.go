@@ -0,0 +1,74 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatusError reports an unsuccessful response from a notification provider.
+// Plugins return it so the delivery queue can decide whether to retry.
+type StatusError struct {
+	StatusCode int
+	RetryAfter time.Duration // Delay requested by the provider, if any
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("received status code: %d", e.StatusCode)
+}
+
+// NewStatusError builds a StatusError from a provider response, honoring its Retry-After header
+func NewStatusError(resp *http.Response) *StatusError {
+	err := &StatusError{StatusCode: resp.StatusCode}
+	if seconds, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil && seconds > 0 {
+		err.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return err
+}
+
+// permanentError marks an error that will not go away by retrying
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so that it is never retried
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// InvalidRecipientsError reports recipients the provider will never deliver to again, such
+// as unregistered push tokens, so they can be removed from jobs. When other recipients were
+// reached, the delivery is recorded as sent with this error kept as its last error.
+type InvalidRecipientsError struct {
+	Recipients []string
+}
+
+func (e *InvalidRecipientsError) Error() string {
+	return fmt.Sprintf("invalid recipients, remove them: %s", strings.Join(e.Recipients, ", "))
+}
+
+// IsRetryable reports whether a failed delivery may succeed on a later attempt.
+// Timeouts, 429 and 5xx responses are retryable, other 4xx responses, invalid recipients
+// and errors wrapped with Permanent are not. Unclassified errors are retried.
+func IsRetryable(err error) bool {
+	var permanent *permanentError
+	var invalid *InvalidRecipientsError
+	if errors.As(err, &permanent) || errors.As(err, &invalid) {
+		return false
+	}
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.StatusCode == 429 || status.StatusCode >= 500
+	}
+	// Network failures and timeouts
+	return true
+}
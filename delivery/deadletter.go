@@ -0,0 +1,118 @@
+package delivery
+
+import (
+	"dynamic-notification-system/config"
+	"dynamic-notification-system/store"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// HandleGetDeadLetters lists every dead letter
+func HandleGetDeadLetters(w http.ResponseWriter, r *http.Request) {
+	deadLetters, err := defaultQueue.store.ListDeadLetters()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deadLetters)
+}
+
+// HandleGetDeadLetter returns a single dead letter
+func HandleGetDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	dl, err := defaultQueue.store.GetDeadLetter(id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "dead letter not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dl)
+}
+
+// HandleReplayDeadLetter queues a dead letter as a new delivery and removes it from the dead-letter table
+func HandleReplayDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	dl, err := defaultQueue.store.GetDeadLetter(id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "dead letter not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	d := config.Delivery{
+		JobID:            dl.JobID,
+		NotificationType: dl.NotificationType,
+		Recipient:        dl.Recipient,
+		Message:          dl.Message,
+		Completed:        dl.Completed, // Recipients reached before it failed aren't notified again
+	}
+	if err := Enqueue(&d); err != nil {
+		http.Error(w, fmt.Sprintf("Error queueing notification: %v", err), http.StatusInternalServerError)
+		return
+	}
+	// It may have been replayed or purged concurrently, the new delivery stands either way
+	if err := defaultQueue.store.DeleteDeadLetter(id); err != nil && !errors.Is(err, store.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"delivery_id": d.ID,
+		"status":      d.Status,
+	})
+}
+
+// HandleDeleteDeadLetter purges a single dead letter
+func HandleDeleteDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	err := defaultQueue.store.DeleteDeadLetter(id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "dead letter not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandlePurgeDeadLetters purges every dead letter
+func HandlePurgeDeadLetters(w http.ResponseWriter, r *http.Request) {
+	n, err := defaultQueue.store.PurgeDeadLetters()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"purged": n,
+	})
+}
@@ -0,0 +1,377 @@
+package delivery
+
+import (
+	"crypto/sha256"
+	"dynamic-notification-system/config"
+	"dynamic-notification-system/plugins"
+	"dynamic-notification-system/store"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWorkers           = 4
+	defaultPollInterval      = 5 * time.Second
+	defaultProcessingTimeout = 5 * time.Minute
+)
+
+// Queue sends the deliveries of a store with a pool of workers. Queues of several
+// instances can share the store, each delivery is claimed by a single one.
+type Queue struct {
+	store      store.DeliveryStore
+	instanceID string
+
+	notifiersMu sync.RWMutex
+	notifiers   []config.Notifier
+
+	wake chan struct{}
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// defaultQueue is the queue started by Initialize, which the handlers and Enqueue use
+var defaultQueue *Queue
+
+// NewQueue returns a queue on top of the store. Deliveries are claimed in the name of
+// instanceID. Nothing is sent until Start is called.
+func NewQueue(instanceID string, st store.DeliveryStore, notifiers []config.Notifier) *Queue {
+	return &Queue{store: st, instanceID: instanceID, notifiers: notifiers}
+}
+
+// Initialize creates the default queue on top of the store and starts it.
+func Initialize(cfg *config.Config, st store.DeliveryStore, loadedNotifiers []config.Notifier) error {
+	defaultQueue = NewQueue(cfg.InstanceID, st, loadedNotifiers)
+	return defaultQueue.Start(cfg.Delivery)
+}
+
+// Default returns the queue started by Initialize
+func Default() *Queue {
+	return defaultQueue
+}
+
+// Start requeues the deliveries this instance was processing when it stopped and starts
+// the worker pool.
+func (q *Queue) Start(cfg config.DeliveryConfig) error {
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	pollInterval := cfg.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+	processingTimeout := cfg.ProcessingTimeout
+	if processingTimeout <= 0 {
+		processingTimeout = defaultProcessingTimeout
+	}
+
+	// Only our own claims are known to be dead, other replicas may still be sending theirs
+	n, err := q.store.RequeueClaimedBy(q.instanceID)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		slog.Info("Requeued interrupted deliveries", "count", n)
+	}
+
+	q.wake = make(chan struct{}, workers)
+	q.stop = make(chan struct{})
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.worker(pollInterval)
+	}
+	q.wg.Add(1)
+	go q.reaper(pollInterval, processingTimeout)
+	slog.Info("Started delivery workers", "workers", workers)
+	return nil
+}
+
+// SetNotifiers replaces the notifiers the default queue uses for the next deliveries
+func SetNotifiers(n []config.Notifier) {
+	if defaultQueue != nil {
+		defaultQueue.SetNotifiers(n)
+	}
+}
+
+// SetNotifiers replaces the notifiers used for the next deliveries
+func (q *Queue) SetNotifiers(n []config.Notifier) {
+	q.notifiersMu.Lock()
+	defer q.notifiersMu.Unlock()
+	q.notifiers = n
+}
+
+func (q *Queue) currentNotifiers() []config.Notifier {
+	q.notifiersMu.RLock()
+	defer q.notifiersMu.RUnlock()
+	return q.notifiers
+}
+
+// Shutdown stops the default queue.
+func Shutdown() {
+	if defaultQueue != nil {
+		defaultQueue.Shutdown()
+	}
+}
+
+// Shutdown stops the workers and waits for in-flight deliveries to finish.
+func (q *Queue) Shutdown() {
+	if q.stop == nil {
+		return
+	}
+	close(q.stop)
+	q.wg.Wait()
+}
+
+// Enqueue stores a delivery in the default queue and wakes an idle worker.
+// It returns store.ErrDuplicateRun when another instance already queued the same scheduled run.
+func Enqueue(d *config.Delivery) error {
+	return defaultQueue.Enqueue(d)
+}
+
+// Enqueue stores a delivery in the queue and wakes an idle worker.
+// It returns store.ErrDuplicateRun when another instance already queued the same scheduled run.
+func (q *Queue) Enqueue(d *config.Delivery) error {
+	hash, err := payloadHash(d.Message)
+	if err != nil {
+		return err
+	}
+	d.PayloadHash = hash
+
+	if err := q.store.InsertDelivery(d); err != nil {
+		return err
+	}
+	q.wakeWorker()
+	return nil
+}
+
+func (q *Queue) wakeWorker() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *Queue) worker(pollInterval time.Duration) {
+	defer q.wg.Done()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Drain the queue before going idle
+		for {
+			select {
+			case <-q.stop:
+				return
+			default:
+			}
+			d, err := q.store.ClaimDelivery(q.instanceID)
+			if err != nil {
+				slog.Error("Error claiming delivery", "error", err)
+				break
+			}
+			if d == nil {
+				break
+			}
+			q.process(d)
+		}
+
+		select {
+		case <-q.stop:
+			return
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// reaper takes over deliveries claimed by instances that stopped before finishing them.
+func (q *Queue) reaper(pollInterval, processingTimeout time.Duration) {
+	defer q.wg.Done()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.stop:
+			return
+		case <-ticker.C:
+		}
+		n, err := q.store.RequeueClaimedBefore(time.Now().Add(-processingTimeout))
+		if err != nil {
+			slog.Error("Error requeueing stale deliveries", "error", err)
+			continue
+		}
+		if n > 0 {
+			slog.Info("Requeued stale deliveries", "count", n)
+			q.wakeWorker()
+		}
+	}
+}
+
+func (q *Queue) process(d *config.Delivery) {
+	notifier, err := q.send(d)
+	// A plugin closed by a reload sent nothing, the delivery goes to the plugin that replaced
+	// it without using up an attempt
+	closed := errors.Is(err, plugins.ErrPluginClosed)
+	if !closed {
+		d.Attempts++
+	}
+	if notifier != nil {
+		d.Channel = channelName(notifier)
+	}
+	if err == nil {
+		if err := q.store.MarkSent(d); err != nil {
+			slog.Error("Error recording delivery status", "delivery", d.ID, "error", err)
+		}
+		return
+	}
+
+	d.LastError = err.Error()
+	d.ResponseCode = nil
+	var status *config.StatusError
+	if errors.As(err, &status) {
+		d.ResponseCode = &status.StatusCode
+	}
+
+	policy := retryPolicy(notifier)
+	if config.IsRetryable(err) && d.Attempts < policy.MaxAttempts {
+		delay := backoff(policy, d.Attempts)
+		if status != nil && status.RetryAfter > delay {
+			delay = status.RetryAfter
+		}
+		if closed {
+			delay = 0
+		}
+		slog.Warn("Delivery failed, retrying", "delivery", d.ID, "attempt", d.Attempts, "max_attempts", policy.MaxAttempts, "delay", delay, "error", err)
+		if err := q.store.ScheduleRetry(d, time.Now().Add(delay)); err != nil {
+			slog.Error("Error scheduling retry", "delivery", d.ID, "error", err)
+			return
+		}
+		time.AfterFunc(delay, q.wakeWorker)
+		return
+	}
+
+	slog.Error("Delivery failed on every attempt, moving it to dead letters", "delivery", d.ID, "attempts", d.Attempts, "error", err)
+	if err := q.store.MarkDead(d); err != nil {
+		slog.Error("Error recording dead letter", "delivery", d.ID, "error", err)
+	}
+}
+
+// send delivers the message through the requested channel, or every notifier of the requested type.
+// Each recipient is notified on its own and added to d.Completed once reached, so a retry only
+// sends to the channels and recipients that failed. It returns the last notifier used, which on
+// failure is the one that failed.
+func (q *Queue) send(d *config.Delivery) (config.Notifier, error) {
+	var used config.Notifier
+	for _, notifier := range plugins.Select(q.currentNotifiers(), d.NotificationType) {
+		used = notifier
+		channel := channelName(notifier)
+		targets := targets(notifier, d.Recipient)
+		var rejected []string
+		for _, recipient := range targets {
+			if d.Completed.Contains(channel, recipient) {
+				continue
+			}
+			slog.Debug("Running delivery", "delivery", d.ID, "channel", channel, "recipient", recipient)
+			// Each send gets its own copy since plugins may modify the message
+			message := d.Message
+			ids, err := notify(notifier, &message, recipient)
+			// A recipient the provider rejected doesn't fail a delivery that reached the others,
+			// it is kept as its last error so it can be pruned
+			var invalid *config.InvalidRecipientsError
+			if errors.As(err, &invalid) {
+				slog.Warn("Provider rejected a recipient, it should be removed", "delivery", d.ID, "channel", channel, "recipient", recipient)
+				d.Completed = append(d.Completed, config.Target{Channel: channel, Recipient: recipient, Rejected: err.Error()})
+				continue
+			}
+			if err != nil {
+				return notifier, fmt.Errorf("sending notification via %s: %w", notifier.Name(), err)
+			}
+			for _, id := range ids {
+				if d.ProviderMessageID != "" {
+					d.ProviderMessageID += ","
+				}
+				d.ProviderMessageID += id
+			}
+			d.Completed = append(d.Completed, config.Target{Channel: channel, Recipient: recipient})
+		}
+
+		// Rejected in this attempt or an earlier one
+		for _, target := range d.Completed {
+			if target.Channel == channel && target.Rejected != "" {
+				rejected = append(rejected, target.Recipient)
+			}
+		}
+		if len(rejected) == len(targets) {
+			return notifier, fmt.Errorf("sending notification via %s: %w", notifier.Name(), &config.InvalidRecipientsError{Recipients: rejected})
+		}
+	}
+	if used == nil {
+		return nil, config.Permanent(fmt.Errorf("no channel or notifier type %q loaded", d.NotificationType))
+	}
+
+	var warnings []string
+	for _, target := range d.Completed {
+		if target.Rejected != "" {
+			warnings = append(warnings, fmt.Sprintf("sending notification via %s: %s", target.Channel, target.Rejected))
+		}
+	}
+	d.LastError = strings.Join(warnings, "; ")
+	return used, nil
+}
+
+// channelName returns the configured channel name of a notifier, falling back to its display name.
+func channelName(notifier config.Notifier) string {
+	if channel, ok := notifier.(*plugins.Channel); ok {
+		return channel.ChannelName
+	}
+	return notifier.Name()
+}
+
+// payloadHash fingerprints the message so identical notifications can be spotted in the history.
+func payloadHash(message config.Message) (string, error) {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return "", fmt.Errorf("encoding message: %w", err)
+	}
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// targets returns the recipients of the delivery the notifier sends to one by one, or a
+// single empty recipient when it sends to the channel's configured target
+func targets(notifier config.Notifier, recipient string) []string {
+	recipients := config.Recipients(recipient)
+	// Channels accept recipients whether or not their plugin can address them
+	if channel, ok := notifier.(*plugins.Channel); ok {
+		notifier = channel.Notifier
+	}
+	_, tracked := notifier.(config.TrackedNotifier)
+	_, addressed := notifier.(config.RecipientNotifier)
+	if len(recipients) == 0 || !tracked && !addressed {
+		return []string{""}
+	}
+	return recipients
+}
+
+// notify sends the message to a recipient, or to the channel's configured target when the
+// recipient is empty. It returns the provider message IDs of notifiers that read them back.
+func notify(notifier config.Notifier, message *config.Message, recipient string) ([]string, error) {
+	var recipients []string
+	if recipient != "" {
+		recipients = []string{recipient}
+	}
+	if tracked, ok := notifier.(config.TrackedNotifier); ok {
+		return tracked.NotifyTracked(message, recipients)
+	}
+	if addressed, ok := notifier.(config.RecipientNotifier); ok && len(recipients) > 0 {
+		return nil, addressed.NotifyRecipients(message, recipients)
+	}
+	return nil, notifier.Notify(message)
+}
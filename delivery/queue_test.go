@@ -0,0 +1,177 @@
+package delivery
+
+import (
+	"dynamic-notification-system/config"
+	"dynamic-notification-system/plugins"
+	"dynamic-notification-system/store"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeNotifier records what it sent. It fails once for each recipient in failures and
+// rejects the invalid ones.
+type fakeNotifier struct {
+	typ      string
+	failures map[string]error
+	invalid  map[string]bool
+
+	mu   sync.Mutex
+	sent []string
+}
+
+func (n *fakeNotifier) Name() string { return "Fake " + n.typ }
+func (n *fakeNotifier) Type() string { return n.typ }
+
+func (n *fakeNotifier) Notify(message *config.Message) error {
+	return n.record("")
+}
+
+func (n *fakeNotifier) record(recipient string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err, ok := n.failures[recipient]; ok {
+		delete(n.failures, recipient)
+		return err
+	}
+	if n.invalid[recipient] {
+		return &config.InvalidRecipientsError{Recipients: []string{recipient}}
+	}
+	n.sent = append(n.sent, recipient)
+	return nil
+}
+
+func (n *fakeNotifier) sentTo() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return strings.Join(n.sent, ",")
+}
+
+// addressedNotifier can send to recipients
+type addressedNotifier struct {
+	*fakeNotifier
+}
+
+func (n addressedNotifier) NotifyRecipients(message *config.Message, recipients []string) error {
+	for _, recipient := range recipients {
+		if err := n.record(recipient); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func channel(name string, notifier config.Notifier) *plugins.Channel {
+	return &plugins.Channel{Notifier: notifier, ChannelName: name}
+}
+
+func TestRetrySkipsCompletedTargets(t *testing.T) {
+	// Both channels have the type the delivery targets
+	email := &fakeNotifier{typ: "all", failures: map[string]error{"b@example.com": errors.New("connection reset")}}
+	chat := &fakeNotifier{typ: "all", failures: map[string]error{"": errors.New("connection reset")}}
+	notifiers := []config.Notifier{
+		channel("email", addressedNotifier{email}),
+		// Ignores the recipients, a single message is posted
+		channel("chat", chat),
+	}
+	q := NewQueue("test", store.NewMemoryStore(), notifiers)
+	d := &config.Delivery{NotificationType: "all", Recipient: "a@example.com,b@example.com,c@example.com"}
+
+	attempts := 0
+	for {
+		attempts++
+		_, err := q.send(d)
+		if err == nil {
+			break
+		}
+		if !config.IsRetryable(err) || attempts == 5 {
+			t.Fatalf("attempt %d: %v", attempts, err)
+		}
+	}
+
+	if attempts != 3 {
+		t.Errorf("sent in %d attempts, want 3", attempts)
+	}
+	if got := email.sentTo(); got != "a@example.com,b@example.com,c@example.com" {
+		t.Errorf("email sent to %s, want each recipient once", got)
+	}
+	if got := chat.sentTo(); got != "" || len(chat.sent) != 1 {
+		t.Errorf("chat posted %d times, want once", len(chat.sent))
+	}
+	if len(d.Completed) != 4 || !d.Completed.Contains("chat", "") || !d.Completed.Contains("email", "b@example.com") {
+		t.Errorf("completed = %+v", d.Completed)
+	}
+}
+
+func TestRejectedRecipients(t *testing.T) {
+	push := &fakeNotifier{typ: "push", invalid: map[string]bool{"old-token": true}}
+	q := NewQueue("test", store.NewMemoryStore(), []config.Notifier{channel("push", addressedNotifier{push})})
+
+	d := &config.Delivery{NotificationType: "push", Recipient: "old-token,new-token"}
+	if _, err := q.send(d); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if !strings.Contains(d.LastError, "old-token") || push.sentTo() != "new-token" {
+		t.Errorf("last error = %q, sent to %s", d.LastError, push.sentTo())
+	}
+
+	// Nothing can be delivered when every recipient is rejected
+	push.invalid["other-token"] = true
+	d = &config.Delivery{NotificationType: "push", Recipient: "old-token,other-token"}
+	_, err := q.send(d)
+	var invalid *config.InvalidRecipientsError
+	if !errors.As(err, &invalid) || len(invalid.Recipients) != 2 || config.IsRetryable(err) {
+		t.Fatalf("send to rejected recipients = %v, want a permanent InvalidRecipientsError for both", err)
+	}
+}
+
+func TestClosedPluginIsNotAnAttempt(t *testing.T) {
+	st := store.NewMemoryStore()
+	sms := &fakeNotifier{typ: "sms", failures: map[string]error{"": fmt.Errorf("sms: %w", plugins.ErrPluginClosed)}}
+	notifiers := []config.Notifier{&plugins.Channel{Notifier: sms, ChannelName: "sms", Config: config.ChannelConfig{Retry: config.RetryConfig{MaxAttempts: 1}}}}
+	q := NewQueue("test", st, notifiers)
+
+	d := &config.Delivery{NotificationType: "sms", Recipient: "+15551234567"}
+	if err := st.InsertDelivery(d); err != nil {
+		t.Fatalf("InsertDelivery: %v", err)
+	}
+	claimed, err := st.ClaimDelivery("test")
+	if err != nil || claimed == nil {
+		t.Fatalf("ClaimDelivery = %v, %v", claimed, err)
+	}
+	// A reload closed the plugin while the delivery was on its way to it
+	q.process(claimed)
+	stored, err := st.GetDelivery(d.ID)
+	if err != nil {
+		t.Fatalf("GetDelivery: %v", err)
+	}
+	if stored.Status != store.StatusPending || stored.Attempts != 0 {
+		t.Fatalf("delivery is %s after %d attempts, want pending after none", stored.Status, stored.Attempts)
+	}
+
+	// The retry goes out right away, through the plugin that replaced it
+	claimed, err = st.ClaimDelivery("test")
+	if err != nil || claimed == nil {
+		t.Fatalf("ClaimDelivery of the retry = %v, %v", claimed, err)
+	}
+	q.process(claimed)
+	if stored, err = st.GetDelivery(d.ID); err != nil || stored.Status != store.StatusSent || stored.Attempts != 1 {
+		t.Errorf("delivery = %+v, %v, want sent after one attempt", stored, err)
+	}
+
+	// Other failures count, and exhaust the single attempt
+	sms.failures[""] = errors.New("connection reset")
+	d = &config.Delivery{NotificationType: "sms", Recipient: "+15551234567"}
+	if err := st.InsertDelivery(d); err != nil {
+		t.Fatalf("InsertDelivery: %v", err)
+	}
+	if claimed, err = st.ClaimDelivery("test"); err != nil || claimed == nil {
+		t.Fatalf("ClaimDelivery = %v, %v", claimed, err)
+	}
+	q.process(claimed)
+	if stored, err = st.GetDelivery(d.ID); err != nil || stored.Status != store.StatusFailed || stored.Attempts != 1 {
+		t.Errorf("delivery = %+v, %v, want failed after one attempt", stored, err)
+	}
+}
@@ -0,0 +1,52 @@
+package delivery
+
+import (
+	"dynamic-notification-system/config"
+	"dynamic-notification-system/plugins"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Defaults for channels that don't configure a retry policy
+const (
+	defaultMaxAttempts = 3
+	defaultBaseDelay   = time.Second
+	defaultMaxDelay    = 5 * time.Minute
+)
+
+// retryPolicy returns the retry settings of the channel the notifier was loaded from,
+// filling in defaults for anything left unset.
+func retryPolicy(notifier config.Notifier) config.RetryConfig {
+	var policy config.RetryConfig
+	if channel, ok := notifier.(*plugins.Channel); ok {
+		policy = channel.Config.Retry
+	}
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = defaultMaxAttempts
+	}
+	if policy.BaseDelay <= 0 {
+		policy.BaseDelay = defaultBaseDelay
+	}
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = defaultMaxDelay
+	}
+	if policy.Jitter < 0 {
+		policy.Jitter = 0
+	}
+	if policy.Jitter > 1 {
+		policy.Jitter = 1
+	}
+	return policy
+}
+
+// backoff returns the delay before the next attempt once `attempts` attempts have failed.
+func backoff(policy config.RetryConfig, attempts int) time.Duration {
+	delay := float64(policy.BaseDelay) * math.Pow(2, float64(attempts-1))
+	if delay > float64(policy.MaxDelay) {
+		delay = float64(policy.MaxDelay)
+	}
+	// Spread retries by ±jitter so failed deliveries don't all come back at once
+	delay *= 1 + policy.Jitter*(2*rand.Float64()-1)
+	return time.Duration(delay)
+}
@@ -0,0 +1,104 @@
+package delivery
+
+import (
+	"dynamic-notification-system/store"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+func pathID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// parseDeliveryFilter reads the status, channel, job_id, from, to and limit query parameters.
+func parseDeliveryFilter(r *http.Request) (store.DeliveryFilter, error) {
+	q := r.URL.Query()
+	filter := store.DeliveryFilter{
+		Status:  q.Get("status"),
+		Channel: q.Get("channel"),
+		Limit:   defaultListLimit,
+	}
+
+	if v := q.Get("job_id"); v != "" {
+		jobID, err := strconv.Atoi(v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid job_id %q", v)
+		}
+		filter.JobID = &jobID
+	}
+	for name, target := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s %q, expected RFC3339 time", name, v)
+			}
+			*target = &t
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return filter, fmt.Errorf("invalid limit %q", v)
+		}
+		if limit > maxListLimit {
+			limit = maxListLimit
+		}
+		filter.Limit = limit
+	}
+	return filter, nil
+}
+
+// HandleGetDeliveries lists deliveries, newest first
+func HandleGetDeliveries(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseDeliveryFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	deliveries, err := defaultQueue.store.ListDeliveries(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// HandleGetDelivery returns the status of a single delivery
+func HandleGetDelivery(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	d, err := defaultQueue.store.GetDelivery(id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "delivery not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d)
+}
@@ -0,0 +1,45 @@
+package logging
+
+import (
+	"dynamic-notification-system/config"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// level is shared by every handler so a reload can change it in place
+var level = new(slog.LevelVar)
+
+// Initialize makes the default logger write leveled, structured records to stderr.
+// Messages logged through the standard log package are recorded at info level.
+func Initialize(cfg config.LogConfig) error {
+	var l slog.Level
+	switch strings.ToLower(cfg.Level) {
+	case "debug":
+		l = slog.LevelDebug
+	case "", "info":
+		l = slog.LevelInfo
+	case "warn", "warning":
+		l = slog.LevelWarn
+	case "error":
+		l = slog.LevelError
+	default:
+		return fmt.Errorf("unknown log level %q, expected debug, info, warn or error", cfg.Level)
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case "", "text":
+		handler = slog.NewTextHandler(os.Stderr, options)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	default:
+		return fmt.Errorf("unknown log format %q, expected text or json", cfg.Format)
+	}
+
+	level.Set(l)
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
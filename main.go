@@ -2,43 +2,85 @@ package main
 
 import (
 	"dynamic-notification-system/config"
+	"dynamic-notification-system/delivery"
+	"dynamic-notification-system/logging"
 	"dynamic-notification-system/notifier"
 	"dynamic-notification-system/plugins"
+	_ "dynamic-notification-system/plugins/builtin" // Bundled channels
+	"dynamic-notification-system/reload"
 	"dynamic-notification-system/scheduler"
+	"dynamic-notification-system/store"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"time"
+	_ "time/tzdata" // Job time zones must resolve even in images without tzdata
 
 	"github.com/gorilla/mux"
 )
 
+const configPath = "config.yaml"
+
 func main() {
 	var err error
 
-	cfg, err := config.LoadConfig("config.yaml")
+	cfg, err := config.LoadConfig(configPath)
 	if err != nil {
-		log.Fatalf("Error loading config: %v", err)
+		fatal("Error loading config", err)
+	}
+	if err := logging.Initialize(cfg.Log); err != nil {
+		fatal("Error configuring logging", err)
+	}
+
+	// "migrate up|down|status" manages the schema and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrate(cfg.Database, os.Args[2:]); err != nil {
+			fatal("Error running migrations", err)
+		}
+		return
 	}
 
 	// Load plugins based on configuration
-	notifiers, err := plugins.LoadPlugins(cfg.Channels)
+	notifiers, err := plugins.LoadPlugins(cfg.Plugins, cfg.Channels)
 	if err != nil {
-		log.Fatalf("Error loading plugins: %v", err)
+		fatal("Error loading plugins", err)
 	}
+	// A reload may replace the notifiers, close whichever set is in use on exit
+	reload.Initialize(configPath, cfg, notifiers)
+	defer func() { plugins.Close(reload.Notifiers()) }()
 
 	// Pass the loaded notifiers to the notifier package
 	notifier.SetNotifiers(notifiers)
 
+	st, err := store.Open(cfg.Database)
+	if err != nil {
+		fatal("Error opening database", err)
+	}
+	defer st.Close()
+
+	// The delivery queue and the scheduler both need an up to date schema
+	if err := store.Migrate(st); err != nil {
+		fatal("Error migrating database", err)
+	}
+
+	// Start the delivery workers that drain the notification queue
+	err = delivery.Initialize(cfg, st, notifiers)
+	if err != nil {
+		fatal("Error initializing delivery queue", err)
+	}
+	defer delivery.Shutdown()
+
 	// Initialize Scheduler if enabled
 	if cfg.Scheduler {
-		fmt.Println("Starting scheduled jobs...")
-		err = scheduler.Initialize(cfg, notifiers)
+		slog.Info("Starting scheduled jobs")
+		err = scheduler.Initialize(cfg, st, notifiers)
 		if err != nil {
-			log.Fatalf("Error initializing scheduler: %v", err)
+			fatal("Error initializing scheduler", err)
 		}
 		defer scheduler.Shutdown()
 	} else {
-		fmt.Println("Scheduler is disabled in the configuration.")
+		slog.Info("Scheduler is disabled in the configuration")
 	}
 
 	r := mux.NewRouter()
@@ -47,12 +89,97 @@ func main() {
 		r.HandleFunc("/schema/job", scheduler.GetJobSchema())
 		r.HandleFunc("/jobs", scheduler.HandlePostJob).Methods("POST")
 		r.HandleFunc("/jobs", scheduler.HandleGetJobs).Methods("GET")
+		r.HandleFunc("/jobs/{id:[0-9]+}", scheduler.HandleGetJob).Methods("GET")
+		r.HandleFunc("/jobs/{id:[0-9]+}", scheduler.HandlePutJob).Methods("PUT")
+		r.HandleFunc("/jobs/{id:[0-9]+}", scheduler.HandlePatchJob).Methods("PATCH")
+		r.HandleFunc("/jobs/{id:[0-9]+}", scheduler.HandleDeleteJob).Methods("DELETE")
+		r.HandleFunc("/jobs/{id:[0-9]+}/pause", scheduler.HandlePauseJob).Methods("POST")
+		r.HandleFunc("/jobs/{id:[0-9]+}/resume", scheduler.HandleResumeJob).Methods("POST")
+		r.HandleFunc("/jobs/{id:[0-9]+}/run", scheduler.HandleRunJob).Methods("POST")
 	} else {
-		fmt.Println("Scheduling endpoints are disabled.")
+		slog.Info("Scheduling endpoints are disabled")
 	}
 	// Instant notification endpoint
 	r.HandleFunc("/notify", notifier.HandlePostJob).Methods("POST")
 
-	fmt.Println("Server listening on port 8080")
-	log.Fatal(http.ListenAndServe(":8080", r))
+	// Delivery status endpoints
+	r.HandleFunc("/deliveries", delivery.HandleGetDeliveries).Methods("GET")
+	r.HandleFunc("/deliveries/{id:[0-9]+}", delivery.HandleGetDelivery).Methods("GET")
+
+	// Dead-letter endpoints for deliveries that failed on every attempt
+	r.HandleFunc("/dead-letters", delivery.HandleGetDeadLetters).Methods("GET")
+	r.HandleFunc("/dead-letters", delivery.HandlePurgeDeadLetters).Methods("DELETE")
+	r.HandleFunc("/dead-letters/{id:[0-9]+}", delivery.HandleGetDeadLetter).Methods("GET")
+	r.HandleFunc("/dead-letters/{id:[0-9]+}", delivery.HandleDeleteDeadLetter).Methods("DELETE")
+	r.HandleFunc("/dead-letters/{id:[0-9]+}/replay", delivery.HandleReplayDeadLetter).Methods("POST")
+
+	// Reload config.yaml and the plugins without a restart
+	r.HandleFunc("/admin/reload", reload.HandleReload).Methods("POST")
+	go reload.Watch()
+
+	// Health of the channels, external plugins failing the periodic check are restarted
+	r.HandleFunc("/admin/health", plugins.HandleHealth(reload.Notifiers)).Methods("GET")
+	go plugins.MonitorHealth(cfg.Plugins.HealthInterval, reload.Notifiers)
+
+	slog.Info("Server listening", "port", 8080)
+	fatal("Error running server", http.ListenAndServe(":8080", r))
+}
+
+// fatal logs the error and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// migrate runs the migrate subcommand: up applies pending migrations, down rolls back
+// the latest one and status lists them all.
+func migrate(cfg config.DatabaseConfig, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s migrate up|down|status", os.Args[0])
+	}
+
+	st, err := store.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	migrator, ok := st.(store.Migrator)
+	if !ok {
+		return fmt.Errorf("the %s store has no schema to migrate", cfg.Driver)
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.MigrateUp()
+		for _, m := range applied {
+			fmt.Printf("Applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("Schema is up to date")
+		}
+		return err
+	case "down":
+		m, err := migrator.MigrateDown()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Rolled back %04d_%s\n", m.Version, m.Name)
+		return nil
+	case "status":
+		status, err := migrator.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = "applied " + s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected up, down or status", args[0])
+	}
 }
@@ -2,19 +2,31 @@ package notifier
 
 import (
 	"dynamic-notification-system/config"
+	"dynamic-notification-system/delivery"
+	"dynamic-notification-system/plugins"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
+	"sync"
 )
 
+var notifiersMu sync.RWMutex
 var notifiers []config.Notifier
 
-// SetNotifiers initializes the notifiers
+// SetNotifiers initializes the notifiers, or replaces them when the configuration is reloaded
 func SetNotifiers(n []config.Notifier) {
+	notifiersMu.Lock()
+	defer notifiersMu.Unlock()
 	notifiers = n
 }
 
+func currentNotifiers() []config.Notifier {
+	notifiersMu.RLock()
+	defer notifiersMu.RUnlock()
+	return notifiers
+}
+
+// HandlePostJob queues an instant notification and returns its delivery ID
 func HandlePostJob(w http.ResponseWriter, r *http.Request) {
 	var job config.InstantJob
 
@@ -28,23 +40,41 @@ func HandlePostJob(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	jobCopy := job
-	for _, notifier := range notifiers {
-		if notifier.Type() == jobCopy.NotificationType {
-			fmt.Printf("Running job: %s \n", jobCopy.Recipient)
-			err := notifier.Notify(&jobCopy.Message)
-			if err != nil {
-				log.Printf("Error sending notification via %s: %v", notifier.Name(), err)
-			}
-		}
+
+	d := config.Delivery{
+		NotificationType: job.NotificationType,
+		Recipient:        job.Recipient,
+		Message:          job.Message,
+	}
+	if err := delivery.Enqueue(&d); err != nil {
+		http.Error(w, fmt.Sprintf("Error queueing notification: %v", err), http.StatusInternalServerError)
+		return
 	}
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(job)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"delivery_id": d.ID,
+		"status":      d.Status,
+	})
 }
 
 func validateJob(job *config.InstantJob) error { // add instant notification
 	if job.NotificationType == "" {
 		return fmt.Errorf("NotificationType is required")
 	}
+	matching := plugins.Select(currentNotifiers(), job.NotificationType)
+	if len(matching) == 0 {
+		return fmt.Errorf("no channel or notifier type %q loaded", job.NotificationType)
+	}
+	if recipients := config.Recipients(job.Recipient); len(recipients) > 0 {
+		for _, notifier := range matching {
+			if validator, ok := notifier.(config.RecipientValidator); ok {
+				if err := validator.ValidateRecipients(recipients); err != nil {
+					return fmt.Errorf("invalid recipient: %w", err)
+				}
+			}
+		}
+	}
 	return nil
 }
@@ -0,0 +1,17 @@
+// Package builtin links every bundled channel into the binary. Importing it for its
+// side effects registers them with the plugins package.
+package builtin
+
+import (
+	_ "dynamic-notification-system/plugins/discord"
+	_ "dynamic-notification-system/plugins/ntfy"
+	_ "dynamic-notification-system/plugins/push"
+	_ "dynamic-notification-system/plugins/rocketchat"
+	_ "dynamic-notification-system/plugins/signal"
+	_ "dynamic-notification-system/plugins/slack"
+	_ "dynamic-notification-system/plugins/sms"
+	_ "dynamic-notification-system/plugins/smtp"
+	_ "dynamic-notification-system/plugins/teams"
+	_ "dynamic-notification-system/plugins/telegram"
+	_ "dynamic-notification-system/plugins/webhook"
+)
@@ -1,14 +1,33 @@
-package main
+package discord
 
 import (
-	"bytes"
 	"dynamic-notification-system/config"
+	"dynamic-notification-system/plugins"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"unicode/utf8"
 )
 
+// configSchema describes the channel settings
+const configSchema = `{
+	"type": "object",
+	"required": ["webhook_url"],
+	"properties": {
+		"webhook_url": {"type": "string", "minLength": 1, "secret": true, "description": "Discord webhook URL"}
+	}
+}`
+
+func init() {
+	plugins.Register("discord", New)
+	plugins.RegisterSchema("discord", configSchema)
+}
+
+// maxContentLength is the longest message Discord accepts
+const maxContentLength = 2000
+
 // DiscordNotifier struct for the Discord channel
 type DiscordNotifier struct {
 	webhookURL string
@@ -24,6 +43,14 @@ func (d *DiscordNotifier) Type() string {
 	return "discord"
 }
 
+// ValidateMessage checks the message fits in a Discord post
+func (d *DiscordNotifier) ValidateMessage(message *config.Message) error {
+	if n := utf8.RuneCountInString(message.Text); n > maxContentLength {
+		return fmt.Errorf("message is %d characters long, Discord accepts at most %d", n, maxContentLength)
+	}
+	return nil
+}
+
 // Notify sends a message to the Discord webhook
 func (d *DiscordNotifier) Notify(message *config.Message) error {
 	if d.webhookURL == "" {
@@ -42,17 +69,17 @@ func (d *DiscordNotifier) Notify(message *config.Message) error {
 	}
 
 	// Send POST request to the Discord webhook
-	resp, err := http.Post(d.webhookURL, "application/json", bytes.NewBuffer(jsonPayload))
+	resp, err := plugins.PostJSON(d.webhookURL, jsonPayload)
 	if err != nil {
 		return fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("failed to send notification, received status code: %d", resp.StatusCode)
+		return fmt.Errorf("failed to send notification, %w", config.NewStatusError(resp))
 	}
 
-	fmt.Println("Notification sent to Discord successfully")
+	slog.Debug("Notification sent", "plugin", "discord")
 	return nil
 }
 
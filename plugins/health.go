@@ -0,0 +1,103 @@
+package plugins
+
+import (
+	"dynamic-notification-system/config"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// defaultHealthInterval is how often channels are probed unless plugins.health_interval is set
+const defaultHealthInterval = 30 * time.Second
+
+// Health statuses
+const (
+	HealthOK        = "ok"
+	HealthUnhealthy = "unhealthy"
+)
+
+// HealthChecker is implemented by notifiers that can tell whether they are able to send,
+// such as external plugins
+type HealthChecker interface {
+	Health() error
+}
+
+// ChannelHealth is the outcome of a channel's health check
+type ChannelHealth struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Health checks the plugin behind the channel. Plugins without a health check are assumed healthy.
+func (c *Channel) Health() error {
+	if checker, ok := c.Notifier.(HealthChecker); ok {
+		return checker.Health()
+	}
+	return nil
+}
+
+// CheckHealth checks every channel, keyed by channel name
+func CheckHealth(notifiers []config.Notifier) map[string]ChannelHealth {
+	health := map[string]ChannelHealth{}
+	for _, notifier := range notifiers {
+		name := notifier.Name()
+		if channel, ok := notifier.(*Channel); ok {
+			name = channel.ChannelName
+		}
+		status := ChannelHealth{Status: HealthOK}
+		if checker, ok := notifier.(HealthChecker); ok {
+			if err := checker.Health(); err != nil {
+				status = ChannelHealth{Status: HealthUnhealthy, Error: err.Error()}
+			}
+		}
+		health[name] = status
+	}
+	return health
+}
+
+// MonitorHealth checks the current channels every interval and restarts the external
+// plugins that fail the check. It never returns.
+func MonitorHealth(interval time.Duration, current func() []config.Notifier) {
+	if interval <= 0 {
+		interval = defaultHealthInterval
+	}
+	for range time.Tick(interval) {
+		for _, notifier := range current() {
+			channel, ok := notifier.(*Channel)
+			if !ok {
+				continue
+			}
+			plugin, ok := channel.Notifier.(*ProcessPlugin)
+			if !ok {
+				continue
+			}
+			err := plugin.Health()
+			// A plugin waiting to be restarted or replaced by a reload needs nothing more
+			if err == nil || errors.Is(err, ErrPluginUnavailable) || errors.Is(err, ErrPluginClosed) {
+				continue
+			}
+			slog.Warn("Plugin failed its health check, restarting it", "channel", channel.ChannelName, "error", err)
+			plugin.Restart()
+		}
+	}
+}
+
+// HandleHealth returns a handler reporting the health of the current channels, with
+// 503 Service Unavailable when any of them is unhealthy
+func HandleHealth(current func() []config.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		health := CheckHealth(current())
+		status := http.StatusOK
+		for _, channel := range health {
+			if channel.Status != HealthOK {
+				status = http.StatusServiceUnavailable
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(health)
+	}
+}
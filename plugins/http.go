@@ -0,0 +1,27 @@
+package plugins
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// client is shared by the webhook channels. The timeout keeps an endpoint that never
+// answers from holding a delivery worker until the delivery is reclaimed and sent again.
+var client = &http.Client{Timeout: 30 * time.Second}
+
+// PostJSON posts a JSON payload to a webhook. Errors leave the URL out, since webhook
+// URLs embed the credentials of the channel.
+func PostJSON(webhookURL string, payload []byte) (*http.Response, error) {
+	resp, err := client.Post(webhookURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, err
+	}
+	return resp, nil
+}
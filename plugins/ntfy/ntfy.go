@@ -1,16 +1,37 @@
-package main
+package ntfy
 
 import (
 	"bytes"
 	"dynamic-notification-system/config"
+	"dynamic-notification-system/plugins"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"time"
 )
 
+// configSchema describes the channel settings
+const configSchema = `{
+	"type": "object",
+	"required": ["api_key", "server"],
+	"properties": {
+		"api_key": {"type": "string", "minLength": 1, "secret": true, "description": "ntfy access token"},
+		"topic": {"type": "string", "minLength": 1, "description": "Topic published to when a delivery has no recipient"},
+		"server": {"type": "string", "minLength": 1, "description": "ntfy server URL"}
+	}
+}`
+
+// client has a timeout so a server that never answers can't hold a delivery worker
+var client = &http.Client{Timeout: 30 * time.Second}
+
+func init() {
+	plugins.Register("ntfy", New)
+	plugins.RegisterSchema("ntfy", configSchema)
+}
+
 // NtfyNotifier handles sending notifications to ntfy
 type NtfyNotifier struct {
 	apiKey string
@@ -28,22 +49,46 @@ func (n *NtfyNotifier) Type() string {
 	return "ntfy"
 }
 
-// Notify sends a notification via ntfy using the Message object
+// ValidateMessage checks the message priority
+func (n *NtfyNotifier) ValidateMessage(message *config.Message) error {
+	if message.Priority < 1 || message.Priority > 5 {
+		return fmt.Errorf("invalid priority value: %d. Must be between 1 and 5", message.Priority)
+	}
+	return nil
+}
+
+// Notify sends a notification via ntfy to the configured topic
 func (n *NtfyNotifier) Notify(message *config.Message) error {
+	return n.NotifyRecipients(message, nil)
+}
+
+// NotifyRecipients publishes the message to every recipient topic, or to the configured
+// topic when there are none
+func (n *NtfyNotifier) NotifyRecipients(message *config.Message, recipients []string) error {
 	if n.apiKey == "" {
 		return errors.New("missing API key for ntfy")
 	}
-	if n.Topic == "" {
-		return errors.New("missing topic for ntfy")
+	topics := config.RecipientsOrDefault(recipients, n.Topic)
+	if len(topics) == 0 {
+		return config.Permanent(errors.New("no recipient and no default topic configured"))
 	}
 
-	// Validate priority
-	if message.Priority < 1 || message.Priority > 5 {
-		return fmt.Errorf("invalid priority value: %d. Must be between 1 and 5", message.Priority)
+	if err := n.ValidateMessage(message); err != nil {
+		return config.Permanent(err)
+	}
+
+	for _, topic := range topics {
+		if err := n.publish(*message, topic); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
+// publish sends the message to one topic
+func (n *NtfyNotifier) publish(message config.Message, topic string) error {
 	// Adding the Topic to the Payload
-	message.Topic = n.Topic
+	message.Topic = topic
 
 	// Marshal the message into JSON for POST body
 	payload, err := json.Marshal(message)
@@ -51,9 +96,6 @@ func (n *NtfyNotifier) Notify(message *config.Message) error {
 		return fmt.Errorf("failed to marshal message: %v", err)
 	}
 
-	// Convert JSON payload to string
-	payloadString := string(payload)
-	log.Printf("Payload: %s", payloadString)
 	// Build the request
 	req, err := http.NewRequest("POST", n.Server, bytes.NewBuffer(payload))
 	if err != nil {
@@ -65,7 +107,7 @@ func (n *NtfyNotifier) Notify(message *config.Message) error {
 	req.Header.Set("Authorization", "Bearer "+n.apiKey)
 
 	// Send the request
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to send notification: %v", err)
 	}
@@ -80,10 +122,10 @@ func (n *NtfyNotifier) Notify(message *config.Message) error {
 		}
 
 		// Print the full response for debugging
-		return fmt.Errorf("ntfy API returned status: %s\nHeaders: %v\nBody: %s",
-			resp.Status, resp.Header, string(body))
+		return fmt.Errorf("ntfy API request failed, %w\nHeaders: %v\nBody: %s",
+			config.NewStatusError(resp), resp.Header, string(body))
 	}
-	fmt.Printf("Message sent to ntfy topic '%s': %s\n", n.Topic, message.Title)
+	slog.Debug("Notification sent", "plugin", "ntfy", "topic", topic)
 	return nil
 }
 
@@ -94,10 +136,7 @@ func New(config map[string]interface{}) (config.Notifier, error) {
 		return nil, errors.New("invalid or missing API key for ntfy")
 	}
 
-	topic, ok := config["topic"].(string)
-	if !ok || topic == "" {
-		return nil, errors.New("invalid or missing topic for ntfy")
-	}
+	topic, _ := config["topic"].(string) // Optional, deliveries usually carry their recipients
 
 	server, ok := config["server"].(string)
 	if !ok || server == "" {
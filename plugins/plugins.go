@@ -3,73 +3,230 @@ package plugins
 import (
 	"dynamic-notification-system/config"
 	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
 	"plugin"
+	"strings"
 )
 
-func LoadPlugins(channelConfigs map[string]config.ChannelConfig) ([]config.Notifier, error) {
+// Channel wraps a loaded notifier with the configuration of the channel it was loaded from
+type Channel struct {
+	config.Notifier
+	ChannelName string
+	Config      config.ChannelConfig
+}
+
+// ValidateMessage delegates to the plugin when it can check messages itself
+func (c *Channel) ValidateMessage(message *config.Message) error {
+	if validator, ok := c.Notifier.(config.MessageValidator); ok {
+		return validator.ValidateMessage(message)
+	}
+	return nil
+}
+
+// NotifyRecipients sends to the recipients when the plugin can address messages, and
+// through its static configuration otherwise
+func (c *Channel) NotifyRecipients(message *config.Message, recipients []string) error {
+	if notifier, ok := c.Notifier.(config.RecipientNotifier); ok {
+		return notifier.NotifyRecipients(message, recipients)
+	}
+	return c.Notifier.Notify(message)
+}
+
+// NotifyTracked returns the provider message IDs when the plugin reads them back
+func (c *Channel) NotifyTracked(message *config.Message, recipients []string) ([]string, error) {
+	if notifier, ok := c.Notifier.(config.TrackedNotifier); ok {
+		return notifier.NotifyTracked(message, recipients)
+	}
+	if len(recipients) > 0 {
+		return nil, c.NotifyRecipients(message, recipients)
+	}
+	return nil, c.Notifier.Notify(message)
+}
+
+// ValidateRecipients delegates to the plugin when it can check recipients itself
+func (c *Channel) ValidateRecipients(recipients []string) error {
+	if validator, ok := c.Notifier.(config.RecipientValidator); ok {
+		return validator.ValidateRecipients(recipients)
+	}
+	return nil
+}
+
+// Plugin modes
+const (
+	ModeAuto    = "auto"
+	ModeBuiltin = "builtin"
+	ModeSO      = "so"
+)
+
+const defaultPluginDir = "plugins"
+
+// LoadPlugins creates a notifier for every enabled channel
+func LoadPlugins(pluginsConfig config.PluginsConfig, channelConfigs map[string]config.ChannelConfig) ([]config.Notifier, error) {
 	var notifiers []config.Notifier
 
 	for name, channelConfig := range channelConfigs {
 		if channelConfig.Enabled {
-			fmt.Printf("[DEBUG] Attempting to load plugin: %s\n", name)
+			slog.Debug("Loading channel", "channel", name)
 
-			// Dynamically load the plugin
-			plug, err := plugin.Open(fmt.Sprintf("plugins/%s.so", name))
-			if err != nil {
-				return nil, fmt.Errorf("error loading plugin %s: %v", name, err)
+			if channelConfig.Type != "" && channelConfig.Plugin != "" && channelConfig.Type != channelConfig.Plugin {
+				Close(notifiers)
+				return nil, fmt.Errorf("channel %s sets both type %q and plugin %q", name, channelConfig.Type, channelConfig.Plugin)
 			}
 
-			fmt.Printf("[DEBUG] Plugin %s loaded successfully.\n", name)
-
-			// Lookup the `New` symbol (constructor)
-			sym, err := plug.Lookup("New")
+			pluginName := PluginName(name, channelConfig)
+			constructor, schema, err := findConstructor(pluginsConfig, pluginName, channelConfig)
 			if err != nil {
-				return nil, fmt.Errorf("error looking up 'New' symbol in plugin %s: %v", name, err)
+				Close(notifiers)
+				return nil, err
 			}
 
-			fmt.Printf("[DEBUG] Symbol 'New' found in plugin %s.\n", name)
-
-			// Assert the symbol's type
-			constructor, ok := sym.(func(map[string]interface{}) (config.Notifier, error))
-			if !ok {
-				fmt.Printf("[DEBUG] Symbol 'New' in plugin %s does not match the expected signature.\n", name)
-				return nil, fmt.Errorf("invalid plugin constructor for %s", name)
+			settings := channelConfig.Settings
+			if settings == nil {
+				settings = map[string]interface{}{}
 			}
-
-			// Convert ChannelConfig to map[string]interface{}
-			configMap := map[string]interface{}{
-				"enabled":      channelConfig.Enabled,
-				"webhook_url":  channelConfig.WebhookURL,
-				"url":          channelConfig.URL,
-				"api_key":      channelConfig.ApiKey,
-				"host":         channelConfig.Host,
-				"port":         channelConfig.Port,
-				"username":     channelConfig.Username,
-				"password":     channelConfig.Password,
-				"to":           channelConfig.To,
-				"device":       channelConfig.Device,
-				"provider_api": channelConfig.ProviderAPI,
-				"phone_number": channelConfig.PhoneNumber,
-				"api_url":      channelConfig.ApiURL,
-				"topic":        channelConfig.Topic,
-				"server":       channelConfig.Server,
+			if err := validateSettings(pluginName, schema, settings); err != nil {
+				Close(notifiers)
+				return nil, fmt.Errorf("invalid configuration for channel %s: %w", name, err)
 			}
 
-			fmt.Printf("[DEBUG] Creating notifier instance for plugin %s with config: %+v\n", name, configMap)
+			slog.Debug("Creating notifier", "channel", name, "plugin", pluginName, "settings", redactSettings(schema, settings))
 
 			// Create the notifier instance
-			notifier, err := constructor(configMap)
+			notifier, err := constructor(settings)
 			if err != nil {
-				fmt.Printf("[DEBUG] Error creating notifier instance for plugin %s: %v\n", name, err)
+				Close(notifiers)
 				return nil, fmt.Errorf("error creating notifier for %s: %v", name, err)
 			}
 
-			fmt.Printf("[DEBUG] Notifier instance for plugin %s created successfully.\n", name)
-			notifiers = append(notifiers, notifier)
+			slog.Debug("Notifier created", "channel", name)
+			notifiers = append(notifiers, &Channel{Notifier: notifier, ChannelName: name, Config: channelConfig})
 		} else {
-			fmt.Printf("[DEBUG] Plugin %s is disabled. Skipping.\n", name)
+			slog.Debug("Channel is disabled, skipping it", "channel", name)
 		}
 	}
 
 	return notifiers, nil
 }
+
+// PluginName returns the plugin implementing a channel: its type, or the channel name
+// when no type is set
+func PluginName(name string, channelConfig config.ChannelConfig) string {
+	if channelConfig.Type != "" {
+		return channelConfig.Type
+	}
+	if channelConfig.Plugin != "" {
+		return channelConfig.Plugin
+	}
+	return name
+}
+
+// TypePrefix marks a target selecting every channel of a type, e.g. type:slack
+const TypePrefix = "type:"
+
+// Select returns the notifiers a job or notification is sent through. A target naming a
+// channel selects only that channel, otherwise every notifier of that type is used. Targets
+// starting with TypePrefix always select by type, even when a channel has the type's name.
+func Select(notifiers []config.Notifier, target string) []config.Notifier {
+	if typ, ok := strings.CutPrefix(target, TypePrefix); ok {
+		return selectType(notifiers, typ)
+	}
+	for _, notifier := range notifiers {
+		if channel, ok := notifier.(*Channel); ok && channel.ChannelName == target {
+			return []config.Notifier{notifier}
+		}
+	}
+	return selectType(notifiers, target)
+}
+
+// selectType returns the notifiers of a type
+func selectType(notifiers []config.Notifier, typ string) []config.Notifier {
+	var matching []config.Notifier
+	for _, notifier := range notifiers {
+		if notifier.Type() == typ {
+			matching = append(matching, notifier)
+		}
+	}
+	return matching
+}
+
+// Close stops the plugins that run in their own process
+func Close(notifiers []config.Notifier) {
+	for _, notifier := range notifiers {
+		if channel, ok := notifier.(*Channel); ok {
+			notifier = channel.Notifier
+		}
+		if closer, ok := notifier.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
+
+// findConstructor returns the constructor of a channel and the schema of its settings, if
+// any: its external executable when a command is configured, otherwise a built-in or .so
+// plugin according to the plugin mode.
+func findConstructor(pluginsConfig config.PluginsConfig, name string, channelConfig config.ChannelConfig) (Constructor, string, error) {
+	if channelConfig.Command != "" {
+		// External plugins check their settings in Configure
+		return func(settings map[string]interface{}) (config.Notifier, error) {
+			return StartProcessPlugin(name, channelConfig, settings)
+		}, "", nil
+	}
+
+	switch pluginsConfig.Mode {
+	case "", ModeAuto:
+		if constructor, ok := lookup(name); ok {
+			return constructor, lookupSchema(name), nil
+		}
+		return openPlugin(pluginsConfig.Dir, name)
+	case ModeBuiltin:
+		constructor, ok := lookup(name)
+		if !ok {
+			return nil, "", fmt.Errorf("no built-in plugin %s, available: %v", name, Registered())
+		}
+		return constructor, lookupSchema(name), nil
+	case ModeSO:
+		return openPlugin(pluginsConfig.Dir, name)
+	default:
+		return nil, "", fmt.Errorf("unknown plugin mode %q, expected auto, builtin or so", pluginsConfig.Mode)
+	}
+}
+
+// openPlugin loads the constructor of a channel from <dir>/<name>.so, along with the
+// optional ConfigSchema variable holding the JSON Schema of its settings.
+func openPlugin(dir, name string) (Constructor, string, error) {
+	if dir == "" {
+		dir = defaultPluginDir
+	}
+
+	// Dynamically load the plugin
+	plug, err := plugin.Open(filepath.Join(dir, name+".so"))
+	if err != nil {
+		return nil, "", fmt.Errorf("error loading plugin %s: %v", name, err)
+	}
+
+	slog.Debug("Plugin file loaded", "plugin", name)
+
+	// Lookup the `New` symbol (constructor)
+	sym, err := plug.Lookup("New")
+	if err != nil {
+		return nil, "", fmt.Errorf("error looking up 'New' symbol in plugin %s: %v", name, err)
+	}
+
+	// Assert the symbol's type
+	constructor, ok := sym.(Constructor)
+	if !ok {
+		return nil, "", fmt.Errorf("invalid plugin constructor for %s", name)
+	}
+
+	var schema string
+	if sym, err := plug.Lookup("ConfigSchema"); err == nil {
+		s, ok := sym.(*string)
+		if !ok {
+			return nil, "", fmt.Errorf("invalid ConfigSchema in plugin %s, expected a string variable", name)
+		}
+		schema = *s
+	}
+	return constructor, schema, nil
+}
@@ -0,0 +1,402 @@
+package plugins
+
+import (
+	"bufio"
+	"dynamic-notification-system/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// ProtocolVersion is the version of the external plugin protocol spoken by the host.
+//
+// External plugins are executables that read JSON-RPC 2.0 requests from stdin and write
+// responses to stdout, one JSON object per line. Anything written to stderr is logged.
+// The host calls Configure once after each start, then Name, Type, Notify and Health.
+// See docs/technical_docs/plugin_protocol.md.
+const ProtocolVersion = 1
+
+const (
+	defaultCallTimeout = 30 * time.Second
+	// minRestartInterval keeps a plugin that crashes on startup from being restarted in a loop
+	minRestartInterval = time.Second
+)
+
+// ErrPluginUnavailable is returned when an external plugin has exited and can't be restarted yet
+var ErrPluginUnavailable = errors.New("plugin process is not running")
+
+// ErrPluginClosed is returned by a plugin replaced by a reload. Deliveries that reach it are
+// retried, by then on the plugin that replaced it.
+var ErrPluginClosed = errors.New("plugin is closed")
+
+type rpcRequest struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      int64       `json:"id"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	ID     int64           `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *rpcError       `json:"error,omitempty"`
+}
+
+// rpcError is a JSON-RPC error. Notify failures may describe the provider response in Data.
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    *struct {
+		StatusCode int  `json:"status_code,omitempty"`
+		RetryAfter int  `json:"retry_after,omitempty"` // Seconds
+		Permanent  bool `json:"permanent,omitempty"`
+	} `json:"data,omitempty"`
+}
+
+// err converts the RPC error so the delivery queue can tell whether to retry
+func (e *rpcError) err() error {
+	err := errors.New(e.Message)
+	if e.Data == nil {
+		return err
+	}
+	if e.Data.StatusCode != 0 {
+		err = fmt.Errorf("%s, %w", e.Message, &config.StatusError{
+			StatusCode: e.Data.StatusCode,
+			RetryAfter: time.Duration(e.Data.RetryAfter) * time.Second,
+		})
+	}
+	if e.Data.Permanent {
+		err = config.Permanent(err)
+	}
+	return err
+}
+
+// process is one running instance of the plugin executable
+type process struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[int64]chan rpcResponse
+	done    chan struct{} // Closed once the process has exited
+}
+
+// ProcessPlugin runs a channel in a separate executable, so plugins can be written in any
+// language and a crash doesn't take the server down. Crashed processes are restarted on
+// the next call and calls that hang past the timeout kill the process.
+type ProcessPlugin struct {
+	channel  string
+	command  string
+	args     []string
+	settings map[string]interface{}
+	timeout  time.Duration
+
+	name string
+	typ  string
+
+	mu        sync.Mutex
+	proc      *process
+	startedAt time.Time
+	nextID    int64
+	closed    bool
+	inflight  sync.WaitGroup // Calls Close waits for
+}
+
+// StartProcessPlugin starts the plugin executable of a channel and configures it.
+func StartProcessPlugin(channel string, channelConfig config.ChannelConfig, settings map[string]interface{}) (*ProcessPlugin, error) {
+	p := &ProcessPlugin{
+		channel:  channel,
+		command:  channelConfig.Command,
+		args:     channelConfig.Args,
+		settings: settings,
+		timeout:  channelConfig.Timeout,
+	}
+	if p.timeout <= 0 {
+		p.timeout = defaultCallTimeout
+	}
+
+	if err := p.call("Name", nil, &p.name); err != nil {
+		p.Close()
+		return nil, fmt.Errorf("plugin %s: %w", channel, err)
+	}
+	if err := p.call("Type", nil, &p.typ); err != nil {
+		p.Close()
+		return nil, fmt.Errorf("plugin %s: %w", channel, err)
+	}
+	return p, nil
+}
+
+// Name returns the name reported by the plugin
+func (p *ProcessPlugin) Name() string {
+	return p.name
+}
+
+// Type returns the type reported by the plugin
+func (p *ProcessPlugin) Type() string {
+	return p.typ
+}
+
+// Notify asks the plugin to send the message
+func (p *ProcessPlugin) Notify(message *config.Message) error {
+	return p.call("Notify", map[string]interface{}{"message": message}, nil)
+}
+
+// NotifyRecipients asks the plugin to send the message to the recipients
+func (p *ProcessPlugin) NotifyRecipients(message *config.Message, recipients []string) error {
+	return p.call("Notify", map[string]interface{}{"message": message, "recipients": recipients}, nil)
+}
+
+// Health checks that the plugin is running and able to send
+func (p *ProcessPlugin) Health() error {
+	return p.call("Health", nil, nil)
+}
+
+// Restart stops the plugin process, the next call starts a new one
+func (p *ProcessPlugin) Restart() {
+	p.mu.Lock()
+	proc := p.proc
+	p.proc = nil
+	p.mu.Unlock()
+
+	if proc != nil {
+		proc.stop()
+	}
+}
+
+// Close stops the plugin process for good, once the calls in progress have returned
+func (p *ProcessPlugin) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	// No call starts once closed is set, and those running end within the call timeout
+	p.inflight.Wait()
+
+	p.mu.Lock()
+	proc := p.proc
+	p.proc = nil
+	p.mu.Unlock()
+
+	if proc != nil {
+		proc.stop()
+	}
+	return nil
+}
+
+// call sends a request to the plugin, starting it first if needed, and decodes the result.
+func (p *ProcessPlugin) call(method string, params interface{}, result interface{}) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return fmt.Errorf("%s: %w", p.channel, ErrPluginClosed)
+	}
+	p.inflight.Add(1)
+	p.mu.Unlock()
+	defer p.inflight.Done()
+
+	proc, err := p.running()
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.nextID++
+	id := p.nextID
+	p.mu.Unlock()
+
+	resp, err := proc.roundTrip(rpcRequest{JSONRPC: "2.0", ID: id, Method: method, Params: params}, p.timeout)
+	if err != nil {
+		if errors.Is(err, errCallTimeout) {
+			// A hung plugin would block every later call, start over with a fresh process
+			slog.Warn("Plugin call timed out, restarting the plugin", "channel", p.channel, "method", method, "timeout", p.timeout)
+			proc.stop()
+		}
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	if resp.Error != nil {
+		return resp.Error.err()
+	}
+	if result != nil {
+		if err := json.Unmarshal(resp.Result, result); err != nil {
+			return fmt.Errorf("%s: decoding result: %w", method, err)
+		}
+	}
+	return nil
+}
+
+// running returns the live process, restarting the plugin if it exited.
+func (p *ProcessPlugin) running() (*process, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, fmt.Errorf("%s: %w", p.channel, ErrPluginClosed)
+	}
+	if p.proc != nil {
+		select {
+		case <-p.proc.done:
+			slog.Warn("Plugin exited, restarting it", "channel", p.channel)
+			p.proc = nil
+		default:
+			return p.proc, nil
+		}
+	}
+	if time.Since(p.startedAt) < minRestartInterval {
+		return nil, ErrPluginUnavailable
+	}
+
+	p.startedAt = time.Now()
+	proc, err := startProcess(p.channel, p.command, p.args)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every new process gets the configuration before anything else
+	p.nextID++
+	resp, err := proc.roundTrip(rpcRequest{
+		JSONRPC: "2.0",
+		ID:      p.nextID,
+		Method:  "Configure",
+		Params:  map[string]interface{}{"protocol_version": ProtocolVersion, "config": p.settings},
+	}, p.timeout)
+	if err == nil && resp.Error != nil {
+		err = resp.Error.err()
+	}
+	if err == nil {
+		var configured struct {
+			ProtocolVersion int `json:"protocol_version"`
+		}
+		if jsonErr := json.Unmarshal(resp.Result, &configured); jsonErr != nil || configured.ProtocolVersion != ProtocolVersion {
+			err = fmt.Errorf("plugin speaks protocol version %d, expected %d", configured.ProtocolVersion, ProtocolVersion)
+		}
+	}
+	if err != nil {
+		proc.stop()
+		return nil, fmt.Errorf("configuring plugin %s: %w", p.channel, err)
+	}
+
+	p.proc = proc
+	return proc, nil
+}
+
+func startProcess(channel, command string, args []string) (*process, error) {
+	cmd := exec.Command(command, args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("creating stdin pipe: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("creating stdout pipe: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("creating stderr pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting plugin %s: %w", channel, err)
+	}
+
+	proc := &process{
+		cmd:     cmd,
+		stdin:   stdin,
+		pending: map[int64]chan rpcResponse{},
+		done:    make(chan struct{}),
+	}
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			slog.Info(scanner.Text(), "plugin", channel)
+		}
+	}()
+	go proc.readResponses(channel, stdout)
+	return proc, nil
+}
+
+// readResponses hands every response to the call waiting for it until the process exits.
+func (proc *process) readResponses(channel string, stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var resp rpcResponse
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			slog.Error("Plugin wrote an invalid response", "channel", channel, "error", err)
+			continue
+		}
+		proc.mu.Lock()
+		ch, ok := proc.pending[resp.ID]
+		delete(proc.pending, resp.ID)
+		proc.mu.Unlock()
+		if ok {
+			ch <- resp
+		}
+	}
+
+	err := proc.cmd.Wait()
+	if err != nil {
+		slog.Warn("Plugin exited", "channel", channel, "error", err)
+	}
+	proc.mu.Lock()
+	close(proc.done)
+	proc.mu.Unlock()
+}
+
+var errCallTimeout = errors.New("plugin call timed out")
+
+func (proc *process) roundTrip(req rpcRequest, timeout time.Duration) (*rpcResponse, error) {
+	ch := make(chan rpcResponse, 1)
+	proc.mu.Lock()
+	select {
+	case <-proc.done:
+		proc.mu.Unlock()
+		return nil, ErrPluginUnavailable
+	default:
+	}
+	proc.pending[req.ID] = ch
+	proc.mu.Unlock()
+
+	defer func() {
+		proc.mu.Lock()
+		delete(proc.pending, req.ID)
+		proc.mu.Unlock()
+	}()
+
+	line, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("encoding request: %w", err)
+	}
+	proc.writeMu.Lock()
+	_, err = proc.stdin.Write(append(line, '\n'))
+	proc.writeMu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("writing request: %w", err)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case resp := <-ch:
+		return &resp, nil
+	case <-proc.done:
+		return nil, ErrPluginUnavailable
+	case <-timer.C:
+		return nil, errCallTimeout
+	}
+}
+
+// stop closes stdin, which well-behaved plugins treat as a request to exit, and kills
+// the process if it is still running shortly after.
+func (proc *process) stop() {
+	proc.stdin.Close()
+	select {
+	case <-proc.done:
+	case <-time.After(2 * time.Second):
+		proc.cmd.Process.Kill()
+		<-proc.done
+	}
+}
@@ -0,0 +1,277 @@
+package plugins
+
+import (
+	"bufio"
+	"dynamic-notification-system/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The test binary doubles as the external plugin: started with GO_WANT_HELPER_PROCESS set,
+// it runs fakePlugin instead of the tests.
+func TestMain(m *testing.M) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") == "1" {
+		fakePlugin(os.Args[1:])
+		os.Exit(0)
+	}
+	os.Setenv("GO_WANT_HELPER_PROCESS", "1")
+	os.Exit(m.Run())
+}
+
+// fakePlugin answers the host one request at a time. Configure reports the protocol version
+// given as the first argument, Name the "name" setting, and Notify acts on the message title.
+func fakePlugin(args []string) {
+	version := ProtocolVersion
+	if len(args) > 0 {
+		fmt.Sscan(args[0], &version)
+	}
+	var settings map[string]interface{}
+
+	out := json.NewEncoder(os.Stdout)
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var req struct {
+			ID     int64           `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			fmt.Fprintln(os.Stderr, "invalid request:", err)
+			continue
+		}
+		resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": nil}
+		switch req.Method {
+		case "Configure":
+			var params struct {
+				Config map[string]interface{} `json:"config"`
+			}
+			json.Unmarshal(req.Params, &params)
+			settings = params.Config
+			resp["result"] = map[string]interface{}{"protocol_version": version}
+		case "Name":
+			resp["result"] = settings["name"]
+		case "Type":
+			resp["result"] = "fake"
+		case "Health":
+		case "Notify":
+			var params struct {
+				Message config.Message `json:"message"`
+			}
+			json.Unmarshal(req.Params, &params)
+			switch params.Message.Title {
+			case "crash":
+				os.Exit(1)
+			case "hang":
+				// Doesn't read stdin anymore, so only a kill ends it
+				time.Sleep(time.Hour)
+			case "slow":
+				time.Sleep(500 * time.Millisecond)
+			case "rate limited":
+				resp["error"] = map[string]interface{}{"code": 1, "message": "too many requests", "data": map[string]interface{}{"status_code": 429, "retry_after": 7}}
+			case "rejected":
+				resp["error"] = map[string]interface{}{"code": 1, "message": "recipient blocked", "data": map[string]interface{}{"status_code": 400, "permanent": true}}
+			case "failed":
+				resp["error"] = map[string]interface{}{"code": 1, "message": "provider unreachable"}
+			}
+		default:
+			resp["error"] = map[string]interface{}{"code": -32601, "message": "method not found"}
+		}
+		if resp["error"] != nil {
+			delete(resp, "result")
+		}
+		out.Encode(resp)
+	}
+}
+
+func startFakePlugin(t *testing.T, timeout time.Duration, args ...string) (*ProcessPlugin, error) {
+	t.Helper()
+	p, err := StartProcessPlugin("fake", config.ChannelConfig{Command: os.Args[0], Args: args, Timeout: timeout}, map[string]interface{}{"name": "Fake"})
+	if p != nil {
+		t.Cleanup(func() { p.Close() })
+	}
+	return p, err
+}
+
+func TestProcessPlugin(t *testing.T) {
+	p, err := startFakePlugin(t, time.Second)
+	if err != nil {
+		t.Fatalf("StartProcessPlugin: %v", err)
+	}
+	// The settings reached the plugin through Configure
+	if p.Name() != "Fake" || p.Type() != "fake" {
+		t.Errorf("Name() = %q, Type() = %q", p.Name(), p.Type())
+	}
+	if err := p.Notify(&config.Message{Title: "hello"}); err != nil {
+		t.Errorf("Notify: %v", err)
+	}
+	if err := p.Health(); err != nil {
+		t.Errorf("Health: %v", err)
+	}
+}
+
+func TestProcessPluginErrors(t *testing.T) {
+	p, err := startFakePlugin(t, time.Second)
+	if err != nil {
+		t.Fatalf("StartProcessPlugin: %v", err)
+	}
+	tests := []struct {
+		title      string
+		statusCode int
+		retryAfter time.Duration
+		retryable  bool
+	}{
+		{"rate limited", 429, 7 * time.Second, true},
+		{"rejected", 400, 0, false},
+		{"failed", 0, 0, true},
+	}
+	for _, tt := range tests {
+		err := p.Notify(&config.Message{Title: tt.title})
+		if err == nil {
+			t.Errorf("%s: Notify succeeded", tt.title)
+			continue
+		}
+		var statusErr *config.StatusError
+		if errors.As(err, &statusErr) != (tt.statusCode != 0) {
+			t.Errorf("%s: error %v, want a status error: %v", tt.title, err, tt.statusCode != 0)
+		} else if statusErr != nil && (statusErr.StatusCode != tt.statusCode || statusErr.RetryAfter != tt.retryAfter) {
+			t.Errorf("%s: status error = %+v, want %d after %s", tt.title, statusErr, tt.statusCode, tt.retryAfter)
+		}
+		if config.IsRetryable(err) != tt.retryable {
+			t.Errorf("%s: retryable = %v, want %v", tt.title, config.IsRetryable(err), tt.retryable)
+		}
+	}
+	// The plugin is still usable after failures it reported
+	if err := p.Notify(&config.Message{Title: "hello"}); err != nil {
+		t.Errorf("Notify after errors: %v", err)
+	}
+}
+
+func TestProcessPluginRestart(t *testing.T) {
+	p, err := startFakePlugin(t, time.Second)
+	if err != nil {
+		t.Fatalf("StartProcessPlugin: %v", err)
+	}
+	if err := p.Notify(&config.Message{Title: "crash"}); !errors.Is(err, ErrPluginUnavailable) {
+		t.Fatalf("Notify crashing the plugin = %v, want ErrPluginUnavailable", err)
+	}
+	// Started less than minRestartInterval ago, so not restarted yet
+	if err := p.Notify(&config.Message{Title: "hello"}); !errors.Is(err, ErrPluginUnavailable) {
+		t.Errorf("Notify right after the crash = %v, want ErrPluginUnavailable", err)
+	}
+
+	time.Sleep(minRestartInterval)
+	if err := p.Notify(&config.Message{Title: "hello"}); err != nil {
+		t.Fatalf("Notify once restartable: %v", err)
+	}
+	// The new process was configured too
+	var name string
+	if err := p.call("Name", nil, &name); err != nil || name != "Fake" {
+		t.Errorf("Name after restart = %q, %v", name, err)
+	}
+}
+
+func TestProcessPluginTimeout(t *testing.T) {
+	p, err := startFakePlugin(t, 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("StartProcessPlugin: %v", err)
+	}
+	p.mu.Lock()
+	hung := p.proc
+	p.mu.Unlock()
+
+	start := time.Now()
+	if err := p.Notify(&config.Message{Title: "hang"}); !errors.Is(err, errCallTimeout) {
+		t.Fatalf("Notify to a hung plugin = %v, want errCallTimeout", err)
+	}
+	// The call gives up at the timeout, the process is killed once it ignored stdin closing
+	select {
+	case <-hung.done:
+	default:
+		t.Fatalf("hung plugin is still running")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("stopping the hung plugin took %s", elapsed)
+	}
+
+	// A fresh process takes over
+	if err := p.Notify(&config.Message{Title: "hello"}); err != nil {
+		t.Errorf("Notify after the timeout: %v", err)
+	}
+}
+
+func TestProcessPluginProtocolVersion(t *testing.T) {
+	_, err := startFakePlugin(t, time.Second, fmt.Sprint(ProtocolVersion+1))
+	if err == nil {
+		t.Fatalf("StartProcessPlugin accepted a plugin speaking another protocol version")
+	}
+	want := fmt.Sprintf("plugin speaks protocol version %d, expected %d", ProtocolVersion+1, ProtocolVersion)
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("error = %v, want it to mention %q", err, want)
+	}
+}
+
+func TestProcessPluginClose(t *testing.T) {
+	p, err := startFakePlugin(t, time.Second)
+	if err != nil {
+		t.Fatalf("StartProcessPlugin: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var notifyErr error
+	var notified time.Time
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		notifyErr = p.Notify(&config.Message{Title: "slow"})
+		notified = time.Now()
+	}()
+	// Let the call reach the plugin
+	time.Sleep(100 * time.Millisecond)
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	closed := time.Now()
+	wg.Wait()
+	if notifyErr != nil {
+		t.Errorf("call in progress during Close: %v", notifyErr)
+	}
+	if closed.Before(notified) {
+		t.Errorf("Close returned before the call in progress")
+	}
+	if err := p.Notify(&config.Message{Title: "hello"}); !errors.Is(err, ErrPluginClosed) {
+		t.Errorf("Notify after Close = %v, want ErrPluginClosed", err)
+	}
+}
+
+func TestRPCError(t *testing.T) {
+	var e rpcError
+	if err := json.Unmarshal([]byte(`{"code": 1, "message": "unavailable", "data": {"status_code": 503, "retry_after": 30}}`), &e); err != nil {
+		t.Fatalf("decoding error: %v", err)
+	}
+	err := e.err()
+	var statusErr *config.StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != 503 || statusErr.RetryAfter != 30*time.Second {
+		t.Errorf("err() = %v, want a 503 status error retried after 30s", err)
+	}
+	if !config.IsRetryable(err) {
+		t.Errorf("503 is not retryable")
+	}
+
+	// Permanent without a status code
+	e = rpcError{Message: "bad template"}
+	e.Data = &struct {
+		StatusCode int  `json:"status_code,omitempty"`
+		RetryAfter int  `json:"retry_after,omitempty"`
+		Permanent  bool `json:"permanent,omitempty"`
+	}{Permanent: true}
+	if err := e.err(); config.IsRetryable(err) || errors.As(err, &statusErr) || err.Error() != "bad template" {
+		t.Errorf("err() = %v, want a permanent error without status", err)
+	}
+}
@@ -0,0 +1,191 @@
+package push
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"dynamic-notification-system/config"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	apnsProductionURL = "https://api.push.apple.com"
+	apnsSandboxURL    = "https://api.sandbox.push.apple.com"
+	// apnsTokenLifetime is how long a provider token is reused. Apple rejects tokens older
+	// than an hour and refreshing them more than every 20 minutes.
+	apnsTokenLifetime = 50 * time.Minute
+)
+
+// APNs environments
+const (
+	EnvironmentProduction = "production"
+	EnvironmentSandbox    = "sandbox"
+)
+
+// apnsInvalidReasons are the APNs errors meaning a device token will never work again
+var apnsInvalidReasons = map[string]bool{
+	"BadDeviceToken":         true,
+	"Unregistered":           true,
+	"DeviceTokenNotForTopic": true,
+}
+
+// apns sends through the Apple Push Notification service, authenticating with a signing
+// key. APNs only speaks HTTP/2, which the default transport negotiates over TLS.
+type apns struct {
+	baseURL  string
+	keyID    string
+	teamID   string
+	topic    string
+	category string
+	key      crypto.Signer
+	client   *http.Client
+
+	mu      sync.Mutex
+	token   string
+	created time.Time
+}
+
+func newAPNs(settings map[string]interface{}, client *http.Client) (*apns, error) {
+	pem, _ := settings["key"].(string)
+	keyID, _ := settings["key_id"].(string)
+	teamID, _ := settings["team_id"].(string)
+	topic, _ := settings["topic"].(string)
+	if pem == "" || keyID == "" || teamID == "" || topic == "" {
+		return nil, errors.New("apns needs key, key_id, team_id and topic")
+	}
+	key, err := parsePrivateKey(pem)
+	if err != nil {
+		return nil, fmt.Errorf("invalid APNs key: %w", err)
+	}
+	if _, ok := key.(*ecdsa.PrivateKey); !ok {
+		return nil, errors.New("invalid APNs key: expected a P-256 key")
+	}
+
+	a := &apns{baseURL: apnsProductionURL, keyID: keyID, teamID: teamID, topic: topic, key: key, client: client}
+	a.category, _ = settings["category"].(string)
+	switch environment, _ := settings["environment"].(string); environment {
+	case "", EnvironmentProduction:
+	case EnvironmentSandbox:
+		a.baseURL = apnsSandboxURL
+	default:
+		return nil, fmt.Errorf("invalid environment %q, expected production or sandbox", environment)
+	}
+	if baseURL, _ := settings["base_url"].(string); baseURL != "" {
+		a.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+	return a, nil
+}
+
+// validate checks that a device token is hexadecimal, as APNs gives them
+func (a *apns) validate(token string) error {
+	if _, err := hex.DecodeString(token); err != nil || len(token) < 64 {
+		return fmt.Errorf("invalid APNs device token %q, expected at least 64 hexadecimal characters", token)
+	}
+	return nil
+}
+
+func (a *apns) send(token string, n *notification) (string, error) {
+	body, err := json.Marshal(a.payload(n))
+	if err != nil {
+		return "", config.Permanent(fmt.Errorf("failed to marshal APNs payload: %w", err))
+	}
+
+	id, err := a.post(token, n, body)
+	var status *config.StatusError
+	if errors.As(err, &status) && status.StatusCode == http.StatusForbidden && strings.Contains(err.Error(), "ExpiredProviderToken") {
+		a.mu.Lock()
+		a.token = ""
+		a.mu.Unlock()
+		id, err = a.post(token, n, body)
+	}
+	return id, err
+}
+
+// payload maps the notification to an APNs payload. The image and actions are custom keys
+// for the app's notification service extension, the category names the buttons it declared.
+func (a *apns) payload(n *notification) map[string]interface{} {
+	aps := map[string]interface{}{
+		"alert": map[string]string{"title": n.title, "body": n.body},
+	}
+	if n.priority >= 4 {
+		aps["sound"] = "default"
+	}
+	payload := map[string]interface{}{"aps": aps}
+	if n.image != "" {
+		aps["mutable-content"] = 1
+		payload["image"] = n.image
+	}
+	if len(n.actions) > 0 {
+		if a.category != "" {
+			aps["category"] = a.category
+		}
+		payload["actions"] = n.actions
+	}
+	return payload
+}
+
+func (a *apns) post(token string, n *notification, body []byte) (string, error) {
+	jwt, err := a.providerToken()
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("POST", a.baseURL+"/3/device/"+token, bytes.NewReader(body))
+	if err != nil {
+		return "", config.Permanent(fmt.Errorf("failed to create request: %w", err))
+	}
+	priority := "5"
+	if n.priority >= 3 {
+		priority = "10"
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "bearer "+jwt)
+	req.Header.Set("apns-topic", a.topic)
+	req.Header.Set("apns-push-type", "alert")
+	req.Header.Set("apns-priority", priority)
+	req.Header.Set("apns-expiration", strconv.FormatInt(time.Now().Add(n.ttl).Unix(), 10))
+
+	resp, err := do(a.client, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var result struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || result.Reason == "" {
+			return "", fmt.Errorf("APNs request failed, %w", config.NewStatusError(resp))
+		}
+		if apnsInvalidReasons[result.Reason] {
+			return "", fmt.Errorf("APNs error %s, %w", result.Reason, errInvalidToken)
+		}
+		return "", fmt.Errorf("APNs error %s, %w", result.Reason, config.NewStatusError(resp))
+	}
+	return resp.Header.Get("apns-id"), nil
+}
+
+// providerToken returns the JWT authenticating requests, signing a new one when the last
+// is about to be rejected
+func (a *apns) providerToken() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.token != "" && time.Since(a.created) < apnsTokenLifetime {
+		return a.token, nil
+	}
+	now := time.Now()
+	token, err := signJWT(map[string]interface{}{"kid": a.keyID}, map[string]interface{}{"iss": a.teamID, "iat": now.Unix()}, a.key)
+	if err != nil {
+		return "", config.Permanent(err)
+	}
+	a.token, a.created = token, now
+	return token, nil
+}
@@ -0,0 +1,288 @@
+package push
+
+import (
+	"bytes"
+	"crypto"
+	"dynamic-notification-system/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	fcmBaseURL  = "https://fcm.googleapis.com"
+	fcmScope    = "https://www.googleapis.com/auth/firebase.messaging"
+	fcmTokenURL = "https://oauth2.googleapis.com/token"
+)
+
+// fcm sends through the Firebase Cloud Messaging HTTP v1 API, authenticating with a
+// service account
+type fcm struct {
+	baseURL     string
+	projectID   string
+	clientEmail string
+	keyID       string
+	tokenURL    string
+	key         crypto.Signer
+	client      *http.Client
+
+	mu          sync.Mutex
+	accessToken string
+	expires     time.Time
+}
+
+// serviceAccount holds the fields of a service account key file that FCM needs
+type serviceAccount struct {
+	ProjectID    string `json:"project_id"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	ClientEmail  string `json:"client_email"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// fcmError is the body of failed FCM requests
+type fcmError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+		Details []struct {
+			ErrorCode string `json:"errorCode"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
+func newFCM(settings map[string]interface{}, client *http.Client) (*fcm, error) {
+	raw, _ := settings["service_account"].(string)
+	if raw == "" {
+		return nil, errors.New("fcm needs service_account")
+	}
+	var account serviceAccount
+	if err := json.Unmarshal([]byte(raw), &account); err != nil {
+		return nil, fmt.Errorf("invalid service_account: %w", err)
+	}
+	if account.ClientEmail == "" || account.PrivateKey == "" {
+		return nil, errors.New("invalid service_account: missing client_email or private_key")
+	}
+	key, err := parsePrivateKey(account.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid service_account private_key: %w", err)
+	}
+
+	f := &fcm{
+		baseURL:     fcmBaseURL,
+		projectID:   account.ProjectID,
+		clientEmail: account.ClientEmail,
+		keyID:       account.PrivateKeyID,
+		tokenURL:    account.TokenURI,
+		key:         key,
+		client:      client,
+	}
+	if projectID, _ := settings["project_id"].(string); projectID != "" {
+		f.projectID = projectID
+	}
+	if f.projectID == "" {
+		return nil, errors.New("fcm needs project_id, none found in the service account")
+	}
+	if f.tokenURL == "" {
+		f.tokenURL = fcmTokenURL
+	}
+	if baseURL, _ := settings["base_url"].(string); baseURL != "" {
+		f.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+	return f, nil
+}
+
+func (f *fcm) validate(token string) error {
+	if strings.TrimSpace(token) == "" || strings.ContainsAny(token, " \t\n") {
+		return fmt.Errorf("invalid FCM registration token %q", token)
+	}
+	return nil
+}
+
+func (f *fcm) send(token string, n *notification) (string, error) {
+	body, err := json.Marshal(map[string]interface{}{"message": f.message(token, n)})
+	if err != nil {
+		return "", config.Permanent(fmt.Errorf("failed to marshal FCM message: %w", err))
+	}
+
+	id, err := f.post(body)
+	var status *config.StatusError
+	if errors.As(err, &status) && status.StatusCode == http.StatusUnauthorized {
+		// The access token was revoked or expired early, get a new one
+		f.mu.Lock()
+		f.accessToken = ""
+		f.mu.Unlock()
+		id, err = f.post(body)
+	}
+	return id, err
+}
+
+// message maps the notification to an FCM message, with platform specific fields for
+// Android, Apple devices and browsers
+func (f *fcm) message(token string, n *notification) map[string]interface{} {
+	content := map[string]interface{}{"title": n.title, "body": n.body}
+	if n.image != "" {
+		content["image"] = n.image
+	}
+
+	androidPriority, apnsPriority := "NORMAL", "5"
+	if n.priority >= 4 {
+		androidPriority = "HIGH"
+	}
+	if n.priority >= 3 {
+		apnsPriority = "10"
+	}
+
+	apns := map[string]interface{}{
+		"headers": map[string]string{
+			"apns-priority":   apnsPriority,
+			"apns-expiration": strconv.FormatInt(time.Now().Add(n.ttl).Unix(), 10),
+		},
+	}
+	if n.image != "" {
+		// The app's notification service extension downloads the image
+		apns["payload"] = map[string]interface{}{"aps": map[string]interface{}{"mutable-content": 1}}
+		apns["fcm_options"] = map[string]string{"image": n.image}
+	}
+
+	webpush := map[string]interface{}{
+		"headers": map[string]string{"Urgency": urgency(n.priority), "TTL": strconv.Itoa(int(n.ttl.Seconds()))},
+	}
+	message := map[string]interface{}{
+		"token":        token,
+		"notification": content,
+		"android": map[string]interface{}{
+			"priority": androidPriority,
+			"ttl":      strconv.Itoa(int(n.ttl.Seconds())) + "s",
+		},
+		"apns":    apns,
+		"webpush": webpush,
+	}
+
+	if len(n.actions) > 0 {
+		// Data values must be strings, apps read the buttons back from JSON
+		encoded, _ := json.Marshal(n.actions)
+		message["data"] = map[string]string{"actions": string(encoded)}
+		webpush["notification"] = map[string]interface{}{"actions": n.actions}
+		if n.actions[0].URL != "" {
+			webpush["fcm_options"] = map[string]string{"link": n.actions[0].URL}
+		}
+	}
+	return message
+}
+
+func (f *fcm) post(body []byte) (string, error) {
+	accessToken, err := f.token()
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("POST", f.baseURL+"/v1/projects/"+url.PathEscape(f.projectID)+"/messages:send", bytes.NewReader(body))
+	if err != nil {
+		return "", config.Permanent(fmt.Errorf("failed to create request: %w", err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := do(f.client, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var result fcmError
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || result.Error.Status == "" {
+			return "", fmt.Errorf("FCM request failed, %w", config.NewStatusError(resp))
+		}
+		code := result.Error.Status
+		for _, detail := range result.Error.Details {
+			if detail.ErrorCode != "" {
+				code = detail.ErrorCode
+			}
+		}
+		switch {
+		case code == "UNREGISTERED", code == "SENDER_ID_MISMATCH",
+			code == "INVALID_ARGUMENT" && strings.Contains(result.Error.Message, "registration token"):
+			return "", fmt.Errorf("FCM error %s: %s, %w", code, result.Error.Message, errInvalidToken)
+		}
+		return "", fmt.Errorf("FCM error %s: %s, %w", code, result.Error.Message, config.NewStatusError(resp))
+	}
+
+	var result struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		// The message was accepted, failing here would have it sent again
+		slog.Warn("Failed to decode FCM response, message ID unknown", "plugin", "push", "error", err)
+		return "", nil
+	}
+	return result.Name, nil
+}
+
+// token returns an OAuth access token for the service account, exchanging a signed JWT for
+// a new one when the last has expired
+func (f *fcm) token() (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.accessToken != "" && time.Now().Before(f.expires) {
+		return f.accessToken, nil
+	}
+
+	now := time.Now()
+	header := map[string]interface{}{}
+	if f.keyID != "" {
+		header["kid"] = f.keyID
+	}
+	assertion, err := signJWT(header, map[string]interface{}{
+		"iss":   f.clientEmail,
+		"scope": fcmScope,
+		"aud":   f.tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}, f.key)
+	if err != nil {
+		return "", config.Permanent(err)
+	}
+
+	form := url.Values{"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"}, "assertion": {assertion}}
+	req, err := http.NewRequest("POST", f.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", config.Permanent(fmt.Errorf("failed to create token request: %w", err))
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := do(f.client, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int    `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	decodeErr := json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		if decodeErr == nil && result.Error != "" {
+			return "", fmt.Errorf("FCM authentication failed: %s %s, %w", result.Error, result.ErrorDescription, config.NewStatusError(resp))
+		}
+		return "", fmt.Errorf("FCM authentication failed, %w", config.NewStatusError(resp))
+	}
+	if decodeErr != nil || result.AccessToken == "" {
+		return "", errors.New("failed to decode FCM access token")
+	}
+
+	f.accessToken = result.AccessToken
+	// Renew a minute early so a token never expires in flight
+	f.expires = now.Add(time.Duration(result.ExpiresIn)*time.Second - time.Minute)
+	return f.accessToken, nil
+}
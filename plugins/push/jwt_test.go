@@ -0,0 +1,111 @@
+package push
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// pkcs8 returns the key in PEM format, as service accounts and .p8 files hold it
+func pkcs8(t *testing.T, key crypto.Signer) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshaling key: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+// verifyJWT checks the token's signature with the public key and returns its header and claims
+func verifyJWT(t *testing.T, token string, public crypto.PublicKey) (map[string]interface{}, map[string]interface{}) {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("token %q has %d parts", token, len(parts))
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	signature := decode(t, parts[2])
+
+	var header, claims map[string]interface{}
+	if err := json.Unmarshal(decode(t, parts[0]), &header); err != nil {
+		t.Fatalf("decoding header: %v", err)
+	}
+	if err := json.Unmarshal(decode(t, parts[1]), &claims); err != nil {
+		t.Fatalf("decoding claims: %v", err)
+	}
+
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		if header["alg"] != "RS256" {
+			t.Errorf("alg = %v, want RS256", header["alg"])
+		}
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature); err != nil {
+			t.Errorf("RS256 signature doesn't verify: %v", err)
+		}
+	case *ecdsa.PublicKey:
+		if header["alg"] != "ES256" {
+			t.Errorf("alg = %v, want ES256", header["alg"])
+		}
+		if len(signature) != 64 {
+			t.Fatalf("ES256 signature is %d bytes, want r and s on 32 bytes each", len(signature))
+		}
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(k, digest[:], r, s) {
+			t.Errorf("ES256 signature doesn't verify")
+		}
+	}
+	if header["typ"] != "JWT" {
+		t.Errorf("typ = %v, want JWT", header["typ"])
+	}
+	return header, claims
+}
+
+func TestSignJWT(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating EC key: %v", err)
+	}
+
+	for _, key := range []crypto.Signer{rsaKey, ecKey} {
+		// Keys go through PEM as the settings give them
+		parsed, err := parsePrivateKey(pkcs8(t, key))
+		if err != nil {
+			t.Fatalf("parsePrivateKey: %v", err)
+		}
+		token, err := signJWT(map[string]interface{}{"kid": "key-1"}, map[string]interface{}{"iss": "team", "iat": 1700000000}, parsed)
+		if err != nil {
+			t.Fatalf("signJWT: %v", err)
+		}
+		header, claims := verifyJWT(t, token, key.Public())
+		if header["kid"] != "key-1" || claims["iss"] != "team" || claims["iat"] != float64(1700000000) {
+			t.Errorf("header = %v, claims = %v", header, claims)
+		}
+	}
+
+	// PKCS#1 and SEC 1 keys are read too
+	ecDER, _ := x509.MarshalECPrivateKey(ecKey)
+	for _, block := range []*pem.Block{
+		{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)},
+		{Type: "EC PRIVATE KEY", Bytes: ecDER},
+	} {
+		if _, err := parsePrivateKey(string(pem.EncodeToMemory(block))); err != nil {
+			t.Errorf("parsePrivateKey(%s): %v", block.Type, err)
+		}
+	}
+	if _, err := parsePrivateKey("not a key"); err == nil {
+		t.Errorf("parsePrivateKey accepted text without a PEM block")
+	}
+}
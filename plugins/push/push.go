@@ -1,15 +1,92 @@
-package main
+package push
 
 import (
 	"dynamic-notification-system/config"
+	"dynamic-notification-system/plugins"
 	"errors"
 	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
 )
 
+// configSchema describes the channel settings
+const configSchema = `{
+	"type": "object",
+	"required": ["provider"],
+	"properties": {
+		"provider": {"enum": ["fcm", "apns", "webpush"], "description": "Push service"},
+		"device": {"type": "string", "minLength": 1, "description": "Device token, or web push subscription as JSON, used when a delivery has no recipient"},
+		"ttl": {"type": "integer", "minimum": 0, "description": "Seconds the push service keeps a notification for an offline device, 4 weeks by default"},
+		"base_url": {"type": "string", "minLength": 1, "description": "FCM or APNs API URL replacing the default one"},
+		"service_account": {"type": "string", "minLength": 1, "secret": true, "description": "FCM service account key as JSON, e.g. file:/run/secrets/firebase.json"},
+		"project_id": {"type": "string", "minLength": 1, "description": "FCM project, read from the service account by default"},
+		"key": {"type": "string", "minLength": 1, "secret": true, "description": "APNs signing key (.p8) in PEM format"},
+		"key_id": {"type": "string", "minLength": 1, "description": "ID of the APNs signing key"},
+		"team_id": {"type": "string", "minLength": 1, "description": "Apple developer team ID"},
+		"topic": {"type": "string", "minLength": 1, "description": "Bundle ID of the iOS app"},
+		"environment": {"enum": ["production", "sandbox"], "description": "APNs environment, production by default"},
+		"category": {"type": "string", "minLength": 1, "description": "APNs category declaring the action buttons, set on messages with actions"},
+		"vapid_public_key": {"type": "string", "minLength": 1, "description": "VAPID public key, base64url encoded"},
+		"vapid_private_key": {"type": "string", "minLength": 1, "secret": true, "description": "VAPID private key, base64url encoded"},
+		"subject": {"type": "string", "pattern": "^(mailto|https):", "description": "Contact given to web push services, a mailto: or https: URL"}
+	},
+	"allOf": [
+		{"if": {"properties": {"provider": {"const": "fcm"}}}, "then": {"required": ["service_account"]}},
+		{"if": {"properties": {"provider": {"const": "apns"}}}, "then": {"required": ["key", "key_id", "team_id", "topic"]}},
+		{"if": {"properties": {"provider": {"const": "webpush"}}}, "then": {"required": ["vapid_public_key", "vapid_private_key", "subject"]}}
+	]
+}`
+
+func init() {
+	plugins.Register("push", New)
+	plugins.RegisterSchema("push", configSchema)
+}
+
+// Providers
+const (
+	ProviderFCM     = "fcm"
+	ProviderAPNs    = "apns"
+	ProviderWebPush = "webpush"
+)
+
+// defaultTTL is how long push services keep a notification for an offline device, the
+// longest FCM and web push services allow
+const defaultTTL = 4 * 7 * 24 * time.Hour
+
+// errInvalidToken is wrapped by providers when the push service will never deliver to a
+// token again, because the app was uninstalled or the subscription expired
+var errInvalidToken = errors.New("invalid device token")
+
+// provider sends a notification to one device and returns the ID the push service gave it
+type provider interface {
+	send(token string, n *notification) (string, error)
+	validate(token string) error
+}
+
+// notification is the message as push providers map it
+type notification struct {
+	title    string
+	body     string
+	image    string
+	priority int // 1=min, 3=default, 5=max
+	actions  []action
+	ttl      time.Duration
+}
+
+// action is a button of the notification
+type action struct {
+	ID    string `json:"action"`
+	Label string `json:"title"`
+	URL   string `json:"url,omitempty"`
+}
+
 // PushNotifier struct for push notifications
 type PushNotifier struct {
-	apiKey string
-	device string
+	provider provider
+	device   string
+	ttl      time.Duration
 }
 
 // Name returns the name of the notifier
@@ -22,24 +99,143 @@ func (p *PushNotifier) Type() string {
 	return "push"
 }
 
-// Notify sends a push notification
+// Notify sends a push notification to the configured device
 func (p *PushNotifier) Notify(message *config.Message) error {
-	fmt.Printf("Sending push notification to device %s with message: %s\n", p.device, message.Text)
-	// WIP (e.g., Firebase, OneSignal)
+	_, err := p.NotifyTracked(message, nil)
+	return err
+}
+
+// NotifyRecipients sends a push notification to each recipient device, or to the configured device when there are none
+func (p *PushNotifier) NotifyRecipients(message *config.Message, recipients []string) error {
+	_, err := p.NotifyTracked(message, recipients)
+	return err
+}
+
+// NotifyTracked sends a push notification to each recipient device, or to the configured
+// device when there are none, and returns the IDs the push service gave them. Devices the
+// push service no longer knows are reported with a config.InvalidRecipientsError once the
+// others were notified.
+func (p *PushNotifier) NotifyTracked(message *config.Message, recipients []string) ([]string, error) {
+	recipients = config.RecipientsOrDefault(recipients, p.device)
+	if len(recipients) == 0 {
+		return nil, config.Permanent(errors.New("no recipient and no default device configured"))
+	}
+	if err := p.ValidateRecipients(recipients); err != nil {
+		return nil, config.Permanent(err)
+	}
+	if message.Title == "" && message.Text == "" {
+		return nil, config.Permanent(errors.New("push notifications need a title or a text"))
+	}
+
+	n := &notification{
+		title:    message.Title,
+		body:     message.Text,
+		image:    message.Attach,
+		priority: message.Priority,
+		actions:  actions(message.Actions),
+		ttl:      p.ttl,
+	}
+	if n.priority == 0 {
+		n.priority = 3
+	}
+
+	var ids, invalid []string
+	for _, token := range recipients {
+		id, err := p.provider.send(token, n)
+		if errors.Is(err, errInvalidToken) {
+			slog.Warn("Push service rejected a device token", "plugin", "push", "error", err)
+			invalid = append(invalid, token)
+			continue
+		}
+		if err != nil {
+			return ids, err
+		}
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if len(invalid) > 0 {
+		return ids, &config.InvalidRecipientsError{Recipients: invalid}
+	}
+	slog.Debug("Notification sent", "plugin", "push", "recipients", len(recipients))
+	return ids, nil
+}
+
+// ValidateRecipients checks that every recipient is a token or subscription the provider can use
+func (p *PushNotifier) ValidateRecipients(recipients []string) error {
+	for _, recipient := range recipients {
+		if err := p.provider.validate(recipient); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// actions reads the action buttons of a message, which are objects with an action, a label
+// and, for view actions, a URL
+func actions(values []interface{}) []action {
+	var result []action
+	for i, value := range values {
+		a, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		label, _ := a["label"].(string)
+		if label == "" {
+			continue
+		}
+		id, _ := a["action"].(string)
+		if id == "" {
+			id = fmt.Sprintf("action-%d", i)
+		}
+		u, _ := a["url"].(string)
+		result = append(result, action{ID: id, Label: label, URL: u})
+	}
+	return result
+}
+
+// do sends a provider request, keeping the URL out of errors since it may hold a token
+func do(client *http.Client, req *http.Request) (*http.Response, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("failed to send push notification: %w", err)
+	}
+	return resp, nil
+}
+
 // New creates a new PushNotifier instance
 func New(config map[string]interface{}) (config.Notifier, error) {
-	apiKey, ok := config["api_key"].(string)
-	device, ok2 := config["device"].(string)
+	device, _ := config["device"].(string) // Optional, deliveries usually carry their recipients
+	ttl := defaultTTL
+	if seconds, ok := config["ttl"].(int); ok {
+		ttl = time.Duration(seconds) * time.Second
+	}
 
-	if !(ok && ok2) {
-		return nil, errors.New("missing or invalid Push Notification configuration")
+	client := &http.Client{Timeout: 30 * time.Second}
+	var p provider
+	var err error
+	switch name, _ := config["provider"].(string); name {
+	case ProviderFCM:
+		p, err = newFCM(config, client)
+	case ProviderAPNs:
+		p, err = newAPNs(config, client)
+	case ProviderWebPush:
+		p, err = newWebPush(config, client)
+	default:
+		err = fmt.Errorf("unknown push provider %q, expected fcm, apns or webpush", name)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	return &PushNotifier{
-		apiKey: apiKey,
-		device: device,
-	}, nil
+	if device != "" {
+		if err := p.validate(device); err != nil {
+			return nil, fmt.Errorf("invalid device: %w", err)
+		}
+	}
+	return &PushNotifier{provider: p, device: device, ttl: ttl}, nil
 }
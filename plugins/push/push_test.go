@@ -0,0 +1,312 @@
+package push
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"dynamic-notification-system/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const apnsDevice = "7b5c1ea0d3e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0"
+
+// request is a push service request received by the fake server
+type request struct {
+	path   string
+	header http.Header
+	body   string
+}
+
+// response is a scripted push service answer
+type response struct {
+	status int
+	body   string
+}
+
+// fakeService stands in for FCM, with its OAuth token endpoint, and for APNs. Sends are
+// answered with the scripted responses, in order, then with success.
+type fakeService struct {
+	mu        sync.Mutex
+	tokens    []request
+	sends     []request
+	responses []response
+}
+
+func (f *fakeService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	req := request{path: r.URL.Path, header: r.Header, body: string(body)}
+
+	if r.URL.Path == "/token" {
+		f.tokens = append(f.tokens, req)
+		fmt.Fprintf(w, `{"access_token": "access-%d", "expires_in": 3600, "token_type": "Bearer"}`, len(f.tokens))
+		return
+	}
+	f.sends = append(f.sends, req)
+	if len(f.responses) > 0 {
+		var resp response
+		resp, f.responses = f.responses[0], f.responses[1:]
+		w.WriteHeader(resp.status)
+		w.Write([]byte(resp.body))
+		return
+	}
+	w.Header().Set("apns-id", "apns-message")
+	fmt.Fprintf(w, `{"name": "projects/notifications/messages/%d"}`, len(f.sends))
+}
+
+func newFCMNotifier(t *testing.T, responses ...response) (*PushNotifier, *fakeService, *rsa.PrivateKey) {
+	t.Helper()
+	service := &fakeService{responses: responses}
+	server := httptest.NewServer(service)
+	t.Cleanup(server.Close)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	account, _ := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "notifications",
+		"private_key_id": "key-1",
+		"private_key":    pkcs8(t, key),
+		"client_email":   "sender@notifications.iam.gserviceaccount.com",
+		"token_uri":      server.URL + "/token",
+	})
+	notifier, err := New(map[string]interface{}{"provider": ProviderFCM, "service_account": string(account), "base_url": server.URL})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return notifier.(*PushNotifier), service, key
+}
+
+func newAPNsNotifier(t *testing.T, responses ...response) (*PushNotifier, *fakeService, *ecdsa.PrivateKey) {
+	t.Helper()
+	service := &fakeService{responses: responses}
+	server := httptest.NewServer(service)
+	t.Cleanup(server.Close)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	notifier, err := New(map[string]interface{}{"provider": ProviderAPNs, "key": pkcs8(t, key), "key_id": "ABC123DEFG", "team_id": "TEAM123456", "topic": "com.example.app", "base_url": server.URL})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return notifier.(*PushNotifier), service, key
+}
+
+func TestFCM(t *testing.T) {
+	notifier, service, key := newFCMNotifier(t)
+	message := &config.Message{Title: "Deploy", Text: "v1.2 is live", Priority: 5}
+	for i := 0; i < 2; i++ {
+		ids, err := notifier.NotifyTracked(message, []string{"device-token"})
+		if err != nil {
+			t.Fatalf("NotifyTracked: %v", err)
+		}
+		if want := fmt.Sprintf("projects/notifications/messages/%d", i+1); len(ids) != 1 || ids[0] != want {
+			t.Errorf("ids = %v, want [%s]", ids, want)
+		}
+	}
+
+	// The access token is reused until it expires
+	if len(service.tokens) != 1 {
+		t.Fatalf("token requests = %d, want 1", len(service.tokens))
+	}
+	form, _ := url.ParseQuery(service.tokens[0].body)
+	if form.Get("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+		t.Errorf("grant_type = %s", form.Get("grant_type"))
+	}
+	header, claims := verifyJWT(t, form.Get("assertion"), &key.PublicKey)
+	if header["kid"] != "key-1" || claims["iss"] != "sender@notifications.iam.gserviceaccount.com" || claims["scope"] != fcmScope || !strings.HasSuffix(claims["aud"].(string), "/token") {
+		t.Errorf("assertion header = %v, claims = %v", header, claims)
+	}
+
+	send := service.sends[0]
+	if send.path != "/v1/projects/notifications/messages:send" || send.header.Get("Authorization") != "Bearer access-1" {
+		t.Errorf("send = %s with %q", send.path, send.header.Get("Authorization"))
+	}
+	var body struct {
+		Message struct {
+			Token        string            `json:"token"`
+			Notification map[string]string `json:"notification"`
+			Android      map[string]string `json:"android"`
+		} `json:"message"`
+	}
+	json.Unmarshal([]byte(send.body), &body)
+	if body.Message.Token != "device-token" || body.Message.Notification["title"] != "Deploy" || body.Message.Notification["body"] != "v1.2 is live" || body.Message.Android["priority"] != "HIGH" {
+		t.Errorf("message = %s", send.body)
+	}
+}
+
+func TestFCMErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		response  response
+		invalid   bool
+		retryable bool
+	}{
+		{"unregistered", response{http.StatusNotFound, `{"error": {"code": 404, "message": "Requested entity was not found.", "status": "NOT_FOUND", "details": [{"@type": "type.googleapis.com/google.firebase.fcm.v1.FcmError", "errorCode": "UNREGISTERED"}]}}`}, true, false},
+		{"sender mismatch", response{http.StatusForbidden, `{"error": {"code": 403, "message": "SenderId mismatch", "status": "PERMISSION_DENIED", "details": [{"errorCode": "SENDER_ID_MISMATCH"}]}}`}, true, false},
+		{"malformed token", response{http.StatusBadRequest, `{"error": {"code": 400, "message": "The registration token is not a valid FCM registration token", "status": "INVALID_ARGUMENT"}}`}, true, false},
+		{"invalid message", response{http.StatusBadRequest, `{"error": {"code": 400, "message": "Invalid value at 'message.android.ttl'", "status": "INVALID_ARGUMENT"}}`}, false, false},
+		{"quota exceeded", response{http.StatusTooManyRequests, `{"error": {"code": 429, "message": "Quota exceeded", "status": "RESOURCE_EXHAUSTED", "details": [{"errorCode": "QUOTA_EXCEEDED"}]}}`}, false, true},
+		{"unavailable", response{http.StatusServiceUnavailable, "Service Unavailable"}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notifier, _, _ := newFCMNotifier(t, tt.response)
+			_, err := notifier.provider.send("device-token", &notification{title: "hello"})
+			if errors.Is(err, errInvalidToken) != tt.invalid {
+				t.Errorf("send = %v, want invalid token %v", err, tt.invalid)
+			}
+			// Invalid tokens are reported by NotifyTracked, other errors decide the retry
+			if !tt.invalid && config.IsRetryable(err) != tt.retryable {
+				t.Errorf("retryable = %v, want %v", config.IsRetryable(err), tt.retryable)
+			}
+		})
+	}
+
+	// Unregistered devices are reported once the others were notified
+	notifier, service, _ := newFCMNotifier(t, tests[0].response)
+	ids, err := notifier.NotifyTracked(&config.Message{Text: "hello"}, []string{"old-token", "new-token"})
+	var invalid *config.InvalidRecipientsError
+	if !errors.As(err, &invalid) || len(invalid.Recipients) != 1 || invalid.Recipients[0] != "old-token" {
+		t.Errorf("NotifyTracked = %v, want old-token reported invalid", err)
+	}
+	if len(ids) != 1 || len(service.sends) != 2 {
+		t.Errorf("ids = %v after %d sends, want new-token notified", ids, len(service.sends))
+	}
+}
+
+func TestFCMTokenRefresh(t *testing.T) {
+	// The access token was revoked, a new one is fetched and the message sent again
+	notifier, service, _ := newFCMNotifier(t, response{http.StatusUnauthorized, `{"error": {"code": 401, "message": "Request had invalid authentication credentials.", "status": "UNAUTHENTICATED"}}`})
+	ids, err := notifier.NotifyTracked(&config.Message{Text: "hello"}, []string{"device-token"})
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("NotifyTracked = %v, %v", ids, err)
+	}
+	if len(service.tokens) != 2 || len(service.sends) != 2 {
+		t.Fatalf("token requests = %d, sends = %d, want 2 each", len(service.tokens), len(service.sends))
+	}
+	if got := service.sends[1].header.Get("Authorization"); got != "Bearer access-2" {
+		t.Errorf("retry authorization = %q, want the new token", got)
+	}
+
+	// Only once, a second 401 is returned
+	unauthorized := response{http.StatusUnauthorized, `{"error": {"code": 401, "message": "Request had invalid authentication credentials.", "status": "UNAUTHENTICATED"}}`}
+	notifier, service, _ = newFCMNotifier(t, unauthorized, unauthorized)
+	_, err = notifier.NotifyTracked(&config.Message{Text: "hello"}, []string{"device-token"})
+	var status *config.StatusError
+	if !errors.As(err, &status) || status.StatusCode != http.StatusUnauthorized || len(service.sends) != 2 {
+		t.Errorf("NotifyTracked = %v after %d sends, want a 401 after one retry", err, len(service.sends))
+	}
+}
+
+func TestFCMUnreadableResponse(t *testing.T) {
+	// Accepted, so not sent again because its ID can't be read
+	notifier, service, _ := newFCMNotifier(t, response{http.StatusOK, "<html>OK</html>"})
+	ids, err := notifier.NotifyTracked(&config.Message{Text: "hello"}, []string{"device-token"})
+	if err != nil || len(ids) != 0 || len(service.sends) != 1 {
+		t.Errorf("NotifyTracked = %v, %v after %d sends, want sent without an ID", ids, err, len(service.sends))
+	}
+}
+
+func TestAPNs(t *testing.T) {
+	notifier, service, key := newAPNsNotifier(t)
+	ids, err := notifier.NotifyTracked(&config.Message{Title: "Deploy", Text: "v1.2 is live", Priority: 4}, []string{apnsDevice})
+	if err != nil || len(ids) != 1 || ids[0] != "apns-message" {
+		t.Fatalf("NotifyTracked = %v, %v", ids, err)
+	}
+
+	send := service.sends[0]
+	if send.path != "/3/device/"+apnsDevice {
+		t.Errorf("path = %s", send.path)
+	}
+	for name, want := range map[string]string{"apns-topic": "com.example.app", "apns-push-type": "alert", "apns-priority": "10"} {
+		if got := send.header.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	jwt, _ := strings.CutPrefix(send.header.Get("Authorization"), "bearer ")
+	header, claims := verifyJWT(t, jwt, &key.PublicKey)
+	if header["kid"] != "ABC123DEFG" || claims["iss"] != "TEAM123456" {
+		t.Errorf("provider token header = %v, claims = %v", header, claims)
+	}
+	var payload struct {
+		APS struct {
+			Alert map[string]string `json:"alert"`
+			Sound string            `json:"sound"`
+		} `json:"aps"`
+	}
+	json.Unmarshal([]byte(send.body), &payload)
+	if payload.APS.Alert["title"] != "Deploy" || payload.APS.Alert["body"] != "v1.2 is live" || payload.APS.Sound != "default" {
+		t.Errorf("payload = %s", send.body)
+	}
+
+	if err := notifier.ValidateRecipients([]string{"device-token"}); err == nil {
+		t.Errorf("ValidateRecipients accepted a token that isn't hexadecimal")
+	}
+}
+
+func TestAPNsErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		response  response
+		invalid   bool
+		retryable bool
+	}{
+		{"bad device token", response{http.StatusBadRequest, `{"reason": "BadDeviceToken"}`}, true, false},
+		{"unregistered", response{http.StatusGone, `{"reason": "Unregistered", "timestamp": 1700000000000}`}, true, false},
+		{"wrong topic", response{http.StatusBadRequest, `{"reason": "DeviceTokenNotForTopic"}`}, true, false},
+		{"payload too large", response{http.StatusRequestEntityTooLarge, `{"reason": "PayloadTooLarge"}`}, false, false},
+		{"too many requests", response{http.StatusTooManyRequests, `{"reason": "TooManyRequests"}`}, false, true},
+		{"unavailable", response{http.StatusServiceUnavailable, `{"reason": "ServiceUnavailable"}`}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notifier, _, _ := newAPNsNotifier(t, tt.response)
+			_, err := notifier.provider.send(apnsDevice, &notification{title: "hello"})
+			if errors.Is(err, errInvalidToken) != tt.invalid {
+				t.Errorf("send = %v, want invalid token %v", err, tt.invalid)
+			}
+			// Invalid tokens are reported by NotifyTracked, other errors decide the retry
+			if !tt.invalid && config.IsRetryable(err) != tt.retryable {
+				t.Errorf("retryable = %v, want %v", config.IsRetryable(err), tt.retryable)
+			}
+		})
+	}
+}
+
+func TestAPNsTokenRefresh(t *testing.T) {
+	notifier, service, key := newAPNsNotifier(t, response{http.StatusForbidden, `{"reason": "ExpiredProviderToken"}`})
+	if _, err := notifier.NotifyTracked(&config.Message{Text: "hello"}, []string{apnsDevice}); err != nil {
+		t.Fatalf("NotifyTracked: %v", err)
+	}
+	if len(service.sends) != 2 {
+		t.Fatalf("sends = %d, want the notification sent again", len(service.sends))
+	}
+	first, second := service.sends[0].header.Get("Authorization"), service.sends[1].header.Get("Authorization")
+	if first == second {
+		t.Errorf("retry used the expired provider token")
+	}
+	jwt, _ := strings.CutPrefix(second, "bearer ")
+	verifyJWT(t, jwt, &key.PublicKey)
+
+	// Other 403 errors are not retried
+	notifier, service, _ = newAPNsNotifier(t, response{http.StatusForbidden, `{"reason": "InvalidProviderToken"}`})
+	if _, err := notifier.NotifyTracked(&config.Message{Text: "hello"}, []string{apnsDevice}); err == nil || len(service.sends) != 1 {
+		t.Errorf("NotifyTracked = %v after %d sends, want a failure without retry", err, len(service.sends))
+	}
+}
@@ -0,0 +1,293 @@
+package push
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"dynamic-notification-system/config"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// recordSize is the aes128gcm record size, push services accept 4096 bytes at most
+	recordSize = 4096
+	// maxPayload is the longest payload fitting in one record, after the 86 byte header,
+	// the padding delimiter and the 16 byte authentication tag
+	maxPayload = recordSize - 86 - 1 - 16
+	// vapidLifetime is how long a VAPID token is valid, push services reject more than 24 hours
+	vapidLifetime = 12 * time.Hour
+)
+
+// webPush sends to browser push subscriptions, encrypting the payload as RFC 8291 requires
+// and identifying the server with VAPID (RFC 8292)
+type webPush struct {
+	publicKey string
+	key       *ecdsa.PrivateKey
+	subject   string
+	client    *http.Client
+
+	mu     sync.Mutex
+	tokens map[string]vapidToken // By push service origin
+}
+
+type vapidToken struct {
+	token   string
+	expires time.Time
+}
+
+// subscription is the PushSubscription a browser hands to the application
+type subscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+func newWebPush(settings map[string]interface{}, client *http.Client) (*webPush, error) {
+	publicKey, _ := settings["vapid_public_key"].(string)
+	privateKey, _ := settings["vapid_private_key"].(string)
+	subject, _ := settings["subject"].(string)
+	if publicKey == "" || privateKey == "" || subject == "" {
+		return nil, errors.New("webpush needs vapid_public_key, vapid_private_key and subject")
+	}
+	if !strings.HasPrefix(subject, "mailto:") && !strings.HasPrefix(subject, "https:") {
+		return nil, fmt.Errorf("invalid subject %q, expected a mailto: or https: URL", subject)
+	}
+
+	d, err := decodeKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vapid_private_key: %w", err)
+	}
+	private, err := ecdh.P256().NewPrivateKey(d)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vapid_private_key: %w", err)
+	}
+	public, err := decodeKey(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vapid_public_key: %w", err)
+	}
+	point := private.PublicKey().Bytes()
+	if !bytes.Equal(public, point) {
+		return nil, errors.New("vapid_public_key doesn't match vapid_private_key")
+	}
+
+	key := &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(point[1:33]),
+			Y:     new(big.Int).SetBytes(point[33:]),
+		},
+		D: new(big.Int).SetBytes(d),
+	}
+	return &webPush{
+		publicKey: b64.EncodeToString(point),
+		key:       key,
+		subject:   subject,
+		client:    client,
+		tokens:    map[string]vapidToken{},
+	}, nil
+}
+
+// decodeKey decodes base64url keys, padded or not. Standard base64 is accepted too.
+func decodeKey(s string) ([]byte, error) {
+	s = strings.TrimRight(strings.TrimSpace(s), "=")
+	if key, err := b64.DecodeString(s); err == nil {
+		return key, nil
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
+
+// parseSubscription reads a subscription given as JSON and the keys it holds
+func parseSubscription(recipient string) (*subscription, *ecdh.PublicKey, []byte, error) {
+	var s subscription
+	if err := json.Unmarshal([]byte(recipient), &s); err != nil {
+		return nil, nil, nil, errors.New("invalid web push subscription, expected JSON with endpoint and keys")
+	}
+	if u, err := url.Parse(s.Endpoint); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, nil, nil, errors.New("invalid web push subscription endpoint")
+	}
+	p256dh, err := decodeKey(s.Keys.P256dh)
+	if err != nil {
+		return nil, nil, nil, errors.New("invalid web push subscription p256dh key")
+	}
+	public, err := ecdh.P256().NewPublicKey(p256dh)
+	if err != nil {
+		return nil, nil, nil, errors.New("invalid web push subscription p256dh key")
+	}
+	auth, err := decodeKey(s.Keys.Auth)
+	if err != nil || len(auth) != 16 {
+		return nil, nil, nil, errors.New("invalid web push subscription auth secret")
+	}
+	return &s, public, auth, nil
+}
+
+func (w *webPush) validate(token string) error {
+	_, _, _, err := parseSubscription(token)
+	return err
+}
+
+func (w *webPush) send(token string, n *notification) (string, error) {
+	sub, public, auth, err := parseSubscription(token)
+	if err != nil {
+		return "", config.Permanent(err)
+	}
+
+	// The service worker shows the notification, the payload follows the Notification API
+	payload := map[string]interface{}{"title": n.title, "body": n.body, "priority": n.priority}
+	if n.image != "" {
+		payload["image"] = n.image
+	}
+	if len(n.actions) > 0 {
+		payload["actions"] = n.actions
+	}
+	plaintext, err := json.Marshal(payload)
+	if err != nil {
+		return "", config.Permanent(fmt.Errorf("failed to marshal web push payload: %w", err))
+	}
+	if len(plaintext) > maxPayload {
+		return "", config.Permanent(fmt.Errorf("web push payload is %d bytes, at most %d fit", len(plaintext), maxPayload))
+	}
+	body, err := encrypt(plaintext, public, auth)
+	if err != nil {
+		return "", config.Permanent(err)
+	}
+
+	endpoint, _ := url.Parse(sub.Endpoint)
+	jwt, err := w.vapid(endpoint.Scheme + "://" + endpoint.Host)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("POST", sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", config.Permanent(fmt.Errorf("failed to create request: %w", err))
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(int(n.ttl.Seconds())))
+	req.Header.Set("Urgency", urgency(n.priority))
+	req.Header.Set("Authorization", "vapid t="+jwt+", k="+w.publicKey)
+
+	resp, err := do(w.client, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return "", fmt.Errorf("web push subscription expired, %w", errInvalidToken)
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return "", fmt.Errorf("web push request failed, %w", config.NewStatusError(resp))
+	}
+	// The push message resource identifies the message at the push service
+	return resp.Header.Get("Location"), nil
+}
+
+// vapid returns the token identifying this server to a push service
+func (w *webPush) vapid(audience string) (string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if t, ok := w.tokens[audience]; ok && time.Now().Before(t.expires) {
+		return t.token, nil
+	}
+	expires := time.Now().Add(vapidLifetime)
+	token, err := signJWT(map[string]interface{}{}, map[string]interface{}{"aud": audience, "exp": expires.Unix(), "sub": w.subject}, w.key)
+	if err != nil {
+		return "", config.Permanent(err)
+	}
+	// Sign again well before the push service would reject the token
+	w.tokens[audience] = vapidToken{token: token, expires: expires.Add(-time.Hour)}
+	return token, nil
+}
+
+// encrypt encrypts a payload for a subscription with the aes128gcm content coding
+// (RFC 8188), deriving the key from an ephemeral ECDH exchange as RFC 8291 describes.
+// The payload is sent as a single record.
+func encrypt(plaintext []byte, uaPublic *ecdh.PublicKey, authSecret []byte) ([]byte, error) {
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generating key: %w", err)
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("generating salt: %w", err)
+	}
+	return seal(plaintext, uaPublic, authSecret, asPrivate, salt)
+}
+
+// seal encrypts like encrypt with the given ephemeral key and salt
+func seal(plaintext []byte, uaPublic *ecdh.PublicKey, authSecret []byte, asPrivate *ecdh.PrivateKey, salt []byte) ([]byte, error) {
+	secret, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, fmt.Errorf("deriving shared secret: %w", err)
+	}
+	asPublic := asPrivate.PublicKey().Bytes()
+
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublic.Bytes()...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm := hkdf(authSecret, secret, keyInfo, 32)
+
+	cek := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// Header: salt, record size, key ID length and the ephemeral public key as key ID
+	header := make([]byte, 0, 21+len(asPublic))
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, recordSize)
+	header = append(header, byte(len(asPublic)))
+	header = append(header, asPublic...)
+	// 0x02 delimits the last record, no padding follows
+	record := append(append([]byte{}, plaintext...), 0x02)
+	return gcm.Seal(header, nonce, record, nil), nil
+}
+
+// hkdf derives length bytes, at most 32, with HKDF-SHA-256 (RFC 5869)
+func hkdf(salt, ikm, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(ikm)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write(info)
+	expand.Write([]byte{1})
+	return expand.Sum(nil)[:length]
+}
+
+// urgency maps a message priority to the Web Push Urgency header
+func urgency(priority int) string {
+	switch {
+	case priority <= 1:
+		return "very-low"
+	case priority == 2:
+		return "low"
+	case priority == 3:
+		return "normal"
+	default:
+		return "high"
+	}
+}
@@ -0,0 +1,85 @@
+package push
+
+import (
+	"crypto/ecdh"
+	"encoding/hex"
+	"testing"
+)
+
+// Test vectors of RFC 8291, appendix A
+const (
+	rfcPlaintext    = "When I grow up, I want to be a watermelon"
+	rfcASPrivate    = "yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw"
+	rfcASPublic     = "BP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A8"
+	rfcUAPublic     = "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4"
+	rfcSalt         = "DGv6ra1nlYgDCS1FRnbzlw"
+	rfcAuthSecret   = "BTBZMqHH6r4Tts7J_aSIgg"
+	rfcECDHSecret   = "kyrL1jIIOHEzg3sM2ZWRHDRB62YACZhhSlknJ672kSs"
+	rfcKeyInfo      = "V2ViUHVzaDogaW5mbwAEJXGyvs3942BVGq8e0PTNNmwRzr5VX4m8t7GGpTM5FzFo7OLr4BhZe9MEebhuPI-OztV3ylkYfpJGmQ22ggCLDgT-M_SrDepxkU21WCP3O1SUj0EwbZIHMtu5pZpTKGSCIA5Zent7wmC6HCJ5mFgJkuk5cwAvMBKiiujwa7t45ewP"
+	rfcIKM          = "S4lYMb_L0FxCeq0WhDx813KgSYqU26kOyzWUdsXYyrg"
+	rfcCEK          = "oIhVW04MRdy2XN9CiKLxTg"
+	rfcNonce        = "4h_95klXJ5E_qnoN"
+	rfcEncryptedMsg = "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN"
+)
+
+func decode(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := b64.DecodeString(s)
+	if err != nil {
+		t.Fatalf("decoding %s: %v", s, err)
+	}
+	return b
+}
+
+func TestHKDF(t *testing.T) {
+	// RFC 5869 test case 1, whose output is cut to the 32 bytes hkdf derives at most
+	ikm, _ := hex.DecodeString("0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b")
+	salt, _ := hex.DecodeString("000102030405060708090a0b0c")
+	info, _ := hex.DecodeString("f0f1f2f3f4f5f6f7f8f9")
+	if got := hex.EncodeToString(hkdf(salt, ikm, info, 32)); got != "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf" {
+		t.Errorf("RFC 5869 OKM = %s", got)
+	}
+
+	// The key derivation of RFC 8291
+	auth, secret, salt := decode(t, rfcAuthSecret), decode(t, rfcECDHSecret), decode(t, rfcSalt)
+	ikm = hkdf(auth, secret, decode(t, rfcKeyInfo), 32)
+	if got := b64.EncodeToString(ikm); got != rfcIKM {
+		t.Errorf("IKM = %s, want %s", got, rfcIKM)
+	}
+	if got := b64.EncodeToString(hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)); got != rfcCEK {
+		t.Errorf("CEK = %s, want %s", got, rfcCEK)
+	}
+	if got := b64.EncodeToString(hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)); got != rfcNonce {
+		t.Errorf("nonce = %s, want %s", got, rfcNonce)
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	asPrivate, err := ecdh.P256().NewPrivateKey(decode(t, rfcASPrivate))
+	if err != nil {
+		t.Fatalf("application server key: %v", err)
+	}
+	if got := b64.EncodeToString(asPrivate.PublicKey().Bytes()); got != rfcASPublic {
+		t.Fatalf("application server public key = %s, want %s", got, rfcASPublic)
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(decode(t, rfcUAPublic))
+	if err != nil {
+		t.Fatalf("user agent key: %v", err)
+	}
+
+	body, err := seal([]byte(rfcPlaintext), uaPublic, decode(t, rfcAuthSecret), asPrivate, decode(t, rfcSalt))
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	// The RFC sends a 4096 byte record size too
+	if got := b64.EncodeToString(body); got != rfcEncryptedMsg {
+		t.Errorf("encrypted message = %s, want %s", got, rfcEncryptedMsg)
+	}
+
+	// A fresh key and salt every time
+	first, _ := encrypt([]byte(rfcPlaintext), uaPublic, decode(t, rfcAuthSecret))
+	second, _ := encrypt([]byte(rfcPlaintext), uaPublic, decode(t, rfcAuthSecret))
+	if len(first) != len(body) || string(first[:16]) == string(second[:16]) || string(first[21:86]) == string(second[21:86]) {
+		t.Errorf("encrypt reused the salt or the ephemeral key")
+	}
+}
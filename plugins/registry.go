@@ -0,0 +1,72 @@
+package plugins
+
+import (
+	"dynamic-notification-system/config"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Constructor creates a notifier from the settings of a channel. Plugins built as .so
+// files export it as their New symbol.
+type Constructor = func(map[string]interface{}) (config.Notifier, error)
+
+var registryMu sync.RWMutex
+var registry = map[string]Constructor{}
+var schemas = map[string]string{}
+
+// Register makes a channel implementation available under the given name. It is meant
+// to be called from the init function of the package implementing the channel, and
+// panics if the name is already taken.
+func Register(name string, constructor Constructor) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if constructor == nil {
+		panic("plugins: Register constructor is nil for " + name)
+	}
+	if _, dup := registry[name]; dup {
+		panic(fmt.Sprintf("plugins: Register called twice for %s", name))
+	}
+	registry[name] = constructor
+}
+
+// RegisterSchema attaches a JSON Schema to a registered plugin. Channel settings are
+// validated against it when the plugin is loaded.
+func RegisterSchema(name string, schema string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := registry[name]; !ok {
+		panic("plugins: RegisterSchema called for unregistered plugin " + name)
+	}
+	schemas[name] = schema
+}
+
+// Registered returns the names of the built-in channel implementations.
+func Registered() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookup(name string) (Constructor, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	constructor, ok := registry[name]
+	return constructor, ok
+}
+
+func lookupSchema(name string) string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	return schemas[name]
+}
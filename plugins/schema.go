@@ -0,0 +1,74 @@
+package plugins
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// validateSettings checks the settings of a channel against the JSON Schema supplied by its plugin.
+func validateSettings(pluginName, schema string, settings map[string]interface{}) error {
+	if schema == "" {
+		return nil
+	}
+
+	url := "plugin:///" + pluginName + ".json"
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource(url, strings.NewReader(schema)); err != nil {
+		return fmt.Errorf("loading schema of plugin %s: %w", pluginName, err)
+	}
+	compiled, err := compiler.Compile(url)
+	if err != nil {
+		return fmt.Errorf("compiling schema of plugin %s: %w", pluginName, err)
+	}
+
+	// Settings come from YAML, validate their JSON form
+	raw, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("encoding settings: %w", err)
+	}
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return fmt.Errorf("decoding settings: %w", err)
+	}
+	return compiled.Validate(doc)
+}
+
+// redacted is logged in place of secret settings
+const redacted = "[REDACTED]"
+
+// redactSettings returns a copy of the settings fit for logging, with the values of the
+// properties marked "secret": true in the schema replaced. Without a schema nothing is
+// known about the settings, so every value is replaced.
+func redactSettings(schema string, settings map[string]interface{}) map[string]interface{} {
+	var parsed map[string]interface{}
+	if schema == "" || json.Unmarshal([]byte(schema), &parsed) != nil {
+		parsed = nil
+	}
+	return redact(parsed, settings)
+}
+
+func redact(schema map[string]interface{}, settings map[string]interface{}) map[string]interface{} {
+	properties, _ := schema["properties"].(map[string]interface{})
+	copied := make(map[string]interface{}, len(settings))
+	for key, value := range settings {
+		property, known := properties[key].(map[string]interface{})
+		switch {
+		case schema == nil:
+			copied[key] = redacted
+		case known && property["secret"] == true:
+			copied[key] = redacted
+		default:
+			if nested, ok := value.(map[string]interface{}); ok && known {
+				value = redact(property, nested)
+			}
+			copied[key] = value
+		}
+	}
+	return copied
+}
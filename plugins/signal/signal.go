@@ -1,15 +1,51 @@
-package main
+package signal
 
 import (
+	"bytes"
 	"dynamic-notification-system/config"
+	"dynamic-notification-system/plugins"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
 )
 
-// SignalNotifier struct for Signal messaging
+// configSchema describes the channel settings
+const configSchema = `{
+	"type": "object",
+	"required": ["number", "api_url"],
+	"properties": {
+		"number": {"type": "string", "pattern": "^\\+[1-9][0-9]{6,14}$", "description": "Number of the Signal account sending the messages"},
+		"phone_number": {"type": "string", "minLength": 1, "description": "Phone number or group.<id> used when a delivery has no recipient"},
+		"api_url": {"type": "string", "minLength": 1, "description": "signal-cli-rest-api URL"}
+	}
+}`
+
+func init() {
+	plugins.Register("signal", New)
+	plugins.RegisterSchema("signal", configSchema)
+}
+
+// maxAttachmentSize is the largest attachment downloaded and forwarded
+const maxAttachmentSize = 25 << 20
+
+// groupPrefix starts the group IDs signal-cli-rest-api expects among recipients
+const groupPrefix = "group."
+
+// SignalNotifier sends messages through signal-cli-rest-api
 type SignalNotifier struct {
+	number      string
 	phoneNumber string
 	apiURL      string
+	client      *http.Client
 }
 
 // Name returns the name of the notifier
@@ -22,24 +58,148 @@ func (s *SignalNotifier) Type() string {
 	return "signal"
 }
 
-// Notify sends a message via Signal
+// Notify sends a message via Signal to the configured phone number
 func (s *SignalNotifier) Notify(message *config.Message) error {
-	fmt.Printf("Sending Signal message to %s: %s\n", s.phoneNumber, message.Text)
-	// WIP
+	return s.NotifyRecipients(message, nil)
+}
+
+// NotifyRecipients sends one message via Signal to every recipient, phone numbers and
+// group.<id> groups alike, or to the configured phone number when there are none
+func (s *SignalNotifier) NotifyRecipients(message *config.Message, recipients []string) error {
+	recipients = config.RecipientsOrDefault(recipients, s.phoneNumber)
+	if len(recipients) == 0 {
+		return config.Permanent(errors.New("no recipient and no default phone number configured"))
+	}
+	if err := s.ValidateRecipients(recipients); err != nil {
+		return config.Permanent(err)
+	}
+
+	request := map[string]interface{}{
+		"number":     s.number,
+		"recipients": recipients,
+		"message":    text(message),
+	}
+	if message.Attach != "" {
+		attachment, err := s.download(message.Attach)
+		if err != nil {
+			return err
+		}
+		request["base64_attachments"] = []string{attachment}
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+	resp, err := s.client.Post(s.apiURL+"/v2/send", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// signal-cli-rest-api explains failures as {"error": "..."}
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(raw, &apiErr) != nil || apiErr.Error == "" {
+			apiErr.Error = strings.TrimSpace(string(raw))
+		}
+		return fmt.Errorf("signal API request failed: %s, %w", apiErr.Error, config.NewStatusError(resp))
+	}
+
+	slog.Debug("Notification sent", "plugin", "signal", "recipients", len(recipients))
+	return nil
+}
+
+// ValidateRecipients checks that every recipient is a phone number in international
+// format or a group.<id> group
+func (s *SignalNotifier) ValidateRecipients(recipients []string) error {
+	for _, recipient := range recipients {
+		if strings.HasPrefix(recipient, groupPrefix) && len(recipient) > len(groupPrefix) {
+			continue
+		}
+		if !isPhoneNumber(recipient) {
+			return fmt.Errorf("invalid Signal recipient %q, expected a number such as +14155550123 or a group.<id>", recipient)
+		}
+	}
 	return nil
 }
 
+// download fetches an attachment and encodes it as the data URI signal-cli-rest-api expects
+func (s *SignalNotifier) download(attach string) (string, error) {
+	resp, err := s.client.Get(attach)
+	if err != nil {
+		return "", fmt.Errorf("failed to download attachment: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("failed to download attachment, %w", config.NewStatusError(resp))
+	}
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxAttachmentSize+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to download attachment: %w", err)
+	}
+	if len(content) > maxAttachmentSize {
+		return "", config.Permanent(fmt.Errorf("attachment is larger than %d MiB", maxAttachmentSize>>20))
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(content)
+	}
+	// Parameters such as the charset would break the data URI
+	contentType, _, _ = mime.ParseMediaType(contentType)
+	name := "attachment"
+	if u, err := url.Parse(attach); err == nil && path.Base(u.Path) != "/" && path.Base(u.Path) != "." {
+		name = path.Base(u.Path)
+	}
+	return fmt.Sprintf("data:%s;filename=%s;base64,%s", contentType, name, base64.StdEncoding.EncodeToString(content)), nil
+}
+
+// text joins the title and the message text
+func text(message *config.Message) string {
+	if message.Title == "" {
+		return message.Text
+	}
+	if message.Text == "" {
+		return message.Title
+	}
+	return message.Title + "\n\n" + message.Text
+}
+
+// isPhoneNumber reports whether s is an E.164 number, e.g. +14155550123
+func isPhoneNumber(s string) bool {
+	if len(s) < 8 || len(s) > 16 || s[0] != '+' || s[1] == '0' {
+		return false
+	}
+	for _, r := range s[1:] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
 // New creates a new SignalNotifier instance
 func New(config map[string]interface{}) (config.Notifier, error) {
-	phoneNumber, ok := config["phone_number"].(string)
+	number, ok := config["number"].(string)
+	phoneNumber, _ := config["phone_number"].(string) // Optional, deliveries usually carry their recipients
 	apiURL, ok2 := config["api_url"].(string)
 
 	if !(ok && ok2) {
 		return nil, errors.New("missing or invalid Signal configuration")
 	}
+	if !isPhoneNumber(number) {
+		return nil, fmt.Errorf("invalid sender number %q, expected international format such as +14155550123", number)
+	}
 
 	return &SignalNotifier{
+		number:      number,
 		phoneNumber: phoneNumber,
-		apiURL:      apiURL,
+		apiURL:      strings.TrimSuffix(apiURL, "/"),
+		client:      &http.Client{Timeout: 30 * time.Second},
 	}, nil
 }
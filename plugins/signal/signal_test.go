@@ -0,0 +1,176 @@
+package signal
+
+import (
+	"dynamic-notification-system/config"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeAPI stands in for signal-cli-rest-api and serves the attachments too
+type fakeAPI struct {
+	t        *testing.T
+	requests []map[string]interface{}
+	status   int
+	response string
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/files/graph.png":
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG fake image"))
+	case "/files/notes":
+		// No Content-Type, which the server would otherwise sniff itself
+		w.Header()["Content-Type"] = nil
+		w.Write([]byte("plain notes"))
+	case "/v2/send":
+		if r.Method != http.MethodPost {
+			f.t.Errorf("send method = %s, want POST", r.Method)
+		}
+		var request map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			f.t.Errorf("decoding send request: %v", err)
+		}
+		f.requests = append(f.requests, request)
+		if f.status != 0 {
+			w.WriteHeader(f.status)
+			w.Write([]byte(f.response))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"timestamp": "1700000000000"}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newNotifier(t *testing.T) (*SignalNotifier, *fakeAPI, string) {
+	t.Helper()
+	api := &fakeAPI{t: t}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	notifier, err := New(map[string]interface{}{"number": "+14155550100", "phone_number": "+14155550123", "api_url": server.URL + "/"})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return notifier.(*SignalNotifier), api, server.URL
+}
+
+func TestSend(t *testing.T) {
+	tests := []struct {
+		name       string
+		recipients []string
+		message    config.Message
+		want       map[string]interface{}
+	}{
+		{
+			name:    "configured number",
+			message: config.Message{Title: "Deploy", Text: "v1.2 is live"},
+			want:    map[string]interface{}{"number": "+14155550100", "recipients": []interface{}{"+14155550123"}, "message": "Deploy\n\nv1.2 is live"},
+		},
+		{
+			name:       "numbers and groups",
+			recipients: []string{"+447700900123", "group.aGVsbG8gd29ybGQ="},
+			message:    config.Message{Text: "Disk almost full"},
+			want:       map[string]interface{}{"number": "+14155550100", "recipients": []interface{}{"+447700900123", "group.aGVsbG8gd29ybGQ="}, "message": "Disk almost full"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notifier, api, _ := newNotifier(t)
+			if err := notifier.NotifyRecipients(&tt.message, tt.recipients); err != nil {
+				t.Fatalf("NotifyRecipients: %v", err)
+			}
+			if len(api.requests) != 1 {
+				t.Fatalf("requests = %d, want 1", len(api.requests))
+			}
+			got, _ := json.Marshal(api.requests[0])
+			want, _ := json.Marshal(tt.want)
+			if string(got) != string(want) {
+				t.Errorf("request = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestInvalidRecipient(t *testing.T) {
+	notifier, api, _ := newNotifier(t)
+	err := notifier.NotifyRecipients(&config.Message{Text: "hello"}, []string{"+14155550123", "0612345678"})
+	if err == nil || config.IsRetryable(err) {
+		t.Errorf("NotifyRecipients = %v, want a permanent error", err)
+	}
+	if len(api.requests) != 0 {
+		t.Errorf("requests = %d, want none", len(api.requests))
+	}
+}
+
+func TestAttachment(t *testing.T) {
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"graph.png", "data:image/png;filename=graph.png;base64," + base64.StdEncoding.EncodeToString([]byte("\x89PNG fake image"))},
+		// Without a Content-Type the content is sniffed
+		{"notes", "data:text/plain;filename=notes;base64," + base64.StdEncoding.EncodeToString([]byte("plain notes"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			notifier, api, serverURL := newNotifier(t)
+			if err := notifier.Notify(&config.Message{Text: "Weekly graph", Attach: serverURL + "/files/" + tt.file}); err != nil {
+				t.Fatalf("Notify: %v", err)
+			}
+			attachments, _ := api.requests[0]["base64_attachments"].([]interface{})
+			if len(attachments) != 1 || attachments[0] != tt.want {
+				t.Errorf("base64_attachments = %v, want [%s]", attachments, tt.want)
+			}
+		})
+	}
+
+	notifier, api, serverURL := newNotifier(t)
+	err := notifier.Notify(&config.Message{Text: "Weekly graph", Attach: serverURL + "/files/missing.png"})
+	var status *config.StatusError
+	if !errors.As(err, &status) || status.StatusCode != http.StatusNotFound {
+		t.Errorf("Notify with a missing attachment = %v, want a 404 StatusError", err)
+	}
+	if len(api.requests) != 0 {
+		t.Errorf("requests = %d, want the message not sent without its attachment", len(api.requests))
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		response  string
+		message   string
+		retryable bool
+	}{
+		{"json error", http.StatusBadRequest, `{"error": "Failed to send message: Unregistered user"}`, "Unregistered user", false},
+		{"server error", http.StatusInternalServerError, `{"error": "signal-cli is not responding"}`, "signal-cli is not responding", true},
+		{"plain text", http.StatusBadGateway, "bad gateway\n", "bad gateway", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notifier, api, _ := newNotifier(t)
+			api.status, api.response = tt.status, tt.response
+			err := notifier.Notify(&config.Message{Text: "hello"})
+
+			var status *config.StatusError
+			if !errors.As(err, &status) || status.StatusCode != tt.status {
+				t.Fatalf("Notify = %v, want a StatusError with status %d", err, tt.status)
+			}
+			if !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("error %q doesn't explain the failure %q", err, tt.message)
+			}
+			if config.IsRetryable(err) != tt.retryable {
+				t.Errorf("retryable = %v, want %v", config.IsRetryable(err), tt.retryable)
+			}
+		})
+	}
+}
@@ -1,14 +1,29 @@
-package main
+package slack
 
 import (
-	"bytes"
 	"dynamic-notification-system/config"
+	"dynamic-notification-system/plugins"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 )
 
+// configSchema describes the channel settings
+const configSchema = `{
+	"type": "object",
+	"required": ["webhook_url"],
+	"properties": {
+		"webhook_url": {"type": "string", "minLength": 1, "secret": true, "description": "Slack incoming webhook URL"}
+	}
+}`
+
+func init() {
+	plugins.Register("slack", New)
+	plugins.RegisterSchema("slack", configSchema)
+}
+
 // SlackNotifier struct for the Slack channel
 type SlackNotifier struct {
 	webhookURL string
@@ -39,17 +54,17 @@ func (s *SlackNotifier) Notify(message *config.Message) error {
 		return fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
-	resp, err := http.Post(s.webhookURL, "application/json", bytes.NewBuffer(jsonPayload))
+	resp, err := plugins.PostJSON(s.webhookURL, jsonPayload)
 	if err != nil {
 		return fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to send notification, received status code: %d", resp.StatusCode)
+		return fmt.Errorf("failed to send notification, %w", config.NewStatusError(resp))
 	}
 
-	fmt.Println("Notification sent to Slack successfully")
+	slog.Debug("Notification sent", "plugin", "slack")
 	return nil
 }
 
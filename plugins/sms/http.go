@@ -0,0 +1,160 @@
+package sms
+
+import (
+	"bytes"
+	"dynamic-notification-system/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// defaultBody is posted to generic providers that don't set a body template
+const defaultBody = `{"to": {{json .To}}, "from": {{json .From}}, "text": {{json .Text}}}`
+
+// templateData is what body and header templates of generic providers can use
+type templateData struct {
+	To       string
+	From     string
+	Text     string
+	Encoding string // GSM-7 or UCS-2
+	APIKey   string
+}
+
+// httpProvider sends through any HTTP API, with the request built from templates
+type httpProvider struct {
+	url           string
+	method        string
+	contentType   string
+	body          *template.Template
+	headers       map[string]*template.Template
+	messageIDPath string
+	from          string
+	apiKey        string
+	client        *http.Client
+}
+
+var templateFuncs = template.FuncMap{
+	// json writes a value as a JSON literal, quotes included
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func newHTTPProvider(settings map[string]interface{}, from string, client *http.Client) (*httpProvider, error) {
+	p := &httpProvider{from: from, client: client, method: "POST", contentType: "application/json"}
+	p.url, _ = settings["provider_api"].(string)
+	if p.url == "" {
+		return nil, errors.New("the http provider needs provider_api")
+	}
+	p.apiKey, _ = settings["api_key"].(string)
+	if method, _ := settings["method"].(string); method != "" {
+		p.method = strings.ToUpper(method)
+	}
+	if contentType, _ := settings["content_type"].(string); contentType != "" {
+		p.contentType = contentType
+	}
+	p.messageIDPath, _ = settings["message_id_path"].(string)
+
+	body, _ := settings["body"].(string)
+	if body == "" {
+		body = defaultBody
+	}
+	var err error
+	if p.body, err = template.New("body").Funcs(templateFuncs).Parse(body); err != nil {
+		return nil, fmt.Errorf("invalid body template: %w", err)
+	}
+
+	headers, _ := settings["headers"].(map[string]interface{})
+	if len(headers) == 0 && p.apiKey != "" {
+		headers = map[string]interface{}{"Authorization": "Bearer {{.APIKey}}"}
+	}
+	p.headers = map[string]*template.Template{}
+	for name, value := range headers {
+		text, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("header %s must be a string", name)
+		}
+		if p.headers[name], err = template.New(name).Funcs(templateFuncs).Parse(text); err != nil {
+			return nil, fmt.Errorf("invalid template for header %s: %w", name, err)
+		}
+	}
+	return p, nil
+}
+
+func (p *httpProvider) send(to, text, enc string) ([]string, error) {
+	data := templateData{To: to, From: p.from, Text: text, Encoding: enc, APIKey: p.apiKey}
+
+	var body bytes.Buffer
+	if err := p.body.Execute(&body, data); err != nil {
+		return nil, config.Permanent(fmt.Errorf("rendering body template: %w", err))
+	}
+	req, err := http.NewRequest(p.method, p.url, &body)
+	if err != nil {
+		return nil, config.Permanent(fmt.Errorf("failed to create request: %w", err))
+	}
+	req.Header.Set("Content-Type", p.contentType)
+	for name, tmpl := range p.headers {
+		var value strings.Builder
+		if err := tmpl.Execute(&value, data); err != nil {
+			return nil, config.Permanent(fmt.Errorf("rendering header %s: %w", name, err))
+		}
+		req.Header.Set(name, value.String())
+	}
+
+	resp, err := do(p.client, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("SMS provider request failed: %s, %w", strings.TrimSpace(string(raw)), config.NewStatusError(resp))
+	}
+
+	if p.messageIDPath == "" {
+		return nil, nil
+	}
+	var result interface{}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		// The message was accepted, failing here would have it sent again
+		slog.Warn("Failed to decode SMS provider response, message ID unknown", "plugin", "sms", "error", err)
+		return nil, nil
+	}
+	if id := lookup(result, p.messageIDPath); id != "" {
+		return []string{id}, nil
+	}
+	return nil, nil
+}
+
+// lookup follows a dotted path such as messages.0.id through decoded JSON
+func lookup(value interface{}, path string) string {
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value = v[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return ""
+			}
+			value = v[i]
+		default:
+			return ""
+		}
+	}
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return ""
+	}
+}
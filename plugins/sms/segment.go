@@ -0,0 +1,86 @@
+package sms
+
+import "strings"
+
+// Encodings of an SMS
+const (
+	EncodingGSM7 = "GSM-7"
+	EncodingUCS2 = "UCS-2"
+)
+
+// Characters of the GSM 03.38 default alphabet, and those of its extension table, which
+// take two septets each
+const (
+	gsm7Basic    = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+	gsm7Extended = "^{}\\[~]|€\f"
+)
+
+// Capacity of a single SMS and of each part of a concatenated one, in septets for GSM-7
+// and in UTF-16 code units for UCS-2. Concatenated parts lose room to the header joining them.
+const (
+	gsm7Single    = 160
+	gsm7Multipart = 153
+	ucs2Single    = 70
+	ucs2Multipart = 67
+)
+
+// encoding returns the encoding a text is sent in: GSM-7 when every character is in the
+// GSM alphabet, UCS-2 otherwise
+func encoding(text string) string {
+	for _, r := range text {
+		if !strings.ContainsRune(gsm7Basic, r) && !strings.ContainsRune(gsm7Extended, r) {
+			return EncodingUCS2
+		}
+	}
+	return EncodingGSM7
+}
+
+// size returns the room a character takes in the given encoding
+func size(r rune, enc string) int {
+	if enc == EncodingUCS2 {
+		if r > 0xFFFF {
+			return 2 // Surrogate pair
+		}
+		return 1
+	}
+	if strings.ContainsRune(gsm7Extended, r) {
+		return 2 // Escape followed by the character
+	}
+	return 1
+}
+
+// segments splits a text into the parts it is sent as. Characters are never split across
+// parts, neither GSM-7 escapes nor UCS-2 surrogate pairs.
+func segments(text string) (string, []string) {
+	enc := encoding(text)
+	single, multipart := gsm7Single, gsm7Multipart
+	if enc == EncodingUCS2 {
+		single, multipart = ucs2Single, ucs2Multipart
+	}
+
+	total := 0
+	for _, r := range text {
+		total += size(r, enc)
+	}
+	if total <= single {
+		return enc, []string{text}
+	}
+
+	var parts []string
+	var part strings.Builder
+	used := 0
+	for _, r := range text {
+		n := size(r, enc)
+		if used+n > multipart {
+			parts = append(parts, part.String())
+			part.Reset()
+			used = 0
+		}
+		part.WriteRune(r)
+		used += n
+	}
+	if part.Len() > 0 {
+		parts = append(parts, part.String())
+	}
+	return enc, parts
+}
@@ -0,0 +1,96 @@
+package sms
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestEncoding(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Disk almost full", EncodingGSM7},
+		{"Für 5€ {oder} [mehr] ~ ^ | \\", EncodingGSM7},
+		{"Ça coûte 5£", EncodingUCS2}, // û isn't in the GSM alphabet, Ç is
+		{"Déploiement terminé", EncodingGSM7},
+		{"Сервер недоступен", EncodingUCS2},
+		{"Build passed 👍", EncodingUCS2},
+	}
+	for _, tt := range tests {
+		if got := encoding(tt.text); got != tt.want {
+			t.Errorf("encoding(%q) = %s, want %s", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSegments(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		enc   string
+		parts []int // Characters in each part
+	}{
+		{"GSM-7 single", strings.Repeat("a", 160), EncodingGSM7, []int{160}},
+		{"GSM-7 multipart", strings.Repeat("a", 161), EncodingGSM7, []int{153, 8}},
+		// Extension characters take two septets
+		{"extension single", strings.Repeat("€", 80), EncodingGSM7, []int{80}},
+		{"extension multipart", strings.Repeat("€", 81), EncodingGSM7, []int{76, 5}},
+		{"extension fills the part", "a" + strings.Repeat("€", 80), EncodingGSM7, []int{77, 4}},
+		// 152 septets in, the next escape and its character would straddle the parts
+		{"escape not split", "ab" + strings.Repeat("€", 80), EncodingGSM7, []int{77, 5}},
+		{"UCS-2 single", strings.Repeat("ж", 70), EncodingUCS2, []int{70}},
+		{"UCS-2 multipart", strings.Repeat("ж", 71), EncodingUCS2, []int{67, 4}},
+		// Surrogate pairs take two code units and are never split
+		{"surrogate pairs single", strings.Repeat("👍", 35), EncodingUCS2, []int{35}},
+		{"surrogate pairs multipart", strings.Repeat("👍", 36), EncodingUCS2, []int{33, 3}},
+		{"surrogate pair not split", "ж" + strings.Repeat("👍", 35), EncodingUCS2, []int{34, 2}},
+		{"surrogate pair at the boundary", "жж" + strings.Repeat("👍", 35), EncodingUCS2, []int{34, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enc, parts := segments(tt.text)
+			if enc != tt.enc {
+				t.Errorf("encoding = %s, want %s", enc, tt.enc)
+			}
+			var lengths []int
+			for _, part := range parts {
+				lengths = append(lengths, utf8.RuneCountInString(part))
+			}
+			if len(lengths) != len(tt.parts) {
+				t.Fatalf("parts = %v, want %v", lengths, tt.parts)
+			}
+			for i := range lengths {
+				if lengths[i] != tt.parts[i] {
+					t.Fatalf("parts = %v, want %v", lengths, tt.parts)
+				}
+			}
+			if strings.Join(parts, "") != tt.text {
+				t.Errorf("parts don't add up to the text")
+			}
+		})
+	}
+}
+
+func TestTexts(t *testing.T) {
+	long := strings.Repeat("a", 153) + strings.Repeat("b", 153) + strings.Repeat("c", 10)
+	tests := []struct {
+		longMessages string
+		maxSegments  int
+		want         []string
+	}{
+		{LongConcatenate, 10, []string{long}},
+		{LongSplit, 10, []string{strings.Repeat("a", 153), strings.Repeat("b", 153), strings.Repeat("c", 10)}},
+		{LongTruncate, 10, []string{strings.Repeat("a", 153)}},
+		{LongConcatenate, 2, []string{strings.Repeat("a", 153) + strings.Repeat("b", 153)}},
+		{LongSplit, 2, []string{strings.Repeat("a", 153), strings.Repeat("b", 153)}},
+	}
+	for _, tt := range tests {
+		s := &SMSNotifier{longMessages: tt.longMessages, maxSegments: tt.maxSegments}
+		_, got := s.texts(long)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%s with max_segments %d sent %d texts, want %d", tt.longMessages, tt.maxSegments, len(got), len(tt.want))
+		}
+	}
+}
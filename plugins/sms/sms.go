@@ -1,16 +1,84 @@
-package main
+package sms
 
 import (
 	"dynamic-notification-system/config"
+	"dynamic-notification-system/plugins"
 	"errors"
 	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
 )
 
+// configSchema describes the channel settings
+const configSchema = `{
+	"type": "object",
+	"properties": {
+		"provider": {"enum": ["twilio", "vonage", "http"], "description": "SMS provider, http by default"},
+		"from": {"type": "string", "minLength": 1, "description": "Sender number, alphanumeric sender ID or Twilio messaging service SID"},
+		"phone_number": {"type": "string", "pattern": "^\\+[1-9][0-9]{1,14}$", "description": "Phone number used when a delivery has no recipient"},
+		"provider_api": {"type": "string", "minLength": 1, "description": "Endpoint of the http provider, or base URL replacing the twilio or vonage one"},
+		"account_sid": {"type": "string", "minLength": 1, "description": "Twilio account SID"},
+		"auth_token": {"type": "string", "minLength": 1, "secret": true, "description": "Twilio auth token"},
+		"api_key": {"type": "string", "minLength": 1, "secret": true, "description": "Vonage API key, or key available to http templates as {{.APIKey}}"},
+		"api_secret": {"type": "string", "minLength": 1, "secret": true, "description": "Vonage API secret"},
+		"method": {"type": "string", "minLength": 1, "description": "HTTP method of the http provider, POST by default"},
+		"content_type": {"type": "string", "minLength": 1, "description": "Content type of the http provider body, application/json by default"},
+		"body": {"type": "string", "minLength": 1, "description": "Body template of the http provider, with {{.To}}, {{.From}}, {{.Text}}, {{.Encoding}} and {{.APIKey}}"},
+		"headers": {"type": "object", "additionalProperties": {"type": "string"}, "secret": true, "description": "Header templates of the http provider"},
+		"message_id_path": {"type": "string", "minLength": 1, "description": "Dotted path to the message ID in the http provider response, e.g. messages.0.id"},
+		"long_messages": {"enum": ["concatenate", "split", "truncate"], "description": "How texts longer than one SMS are sent, concatenate by default"},
+		"max_segments": {"type": "integer", "minimum": 1, "maximum": 20, "description": "Most segments sent per message, 10 by default"}
+	},
+	"allOf": [
+		{"if": {"properties": {"provider": {"const": "twilio"}}, "required": ["provider"]}, "then": {"required": ["account_sid", "auth_token", "from"]}},
+		{"if": {"properties": {"provider": {"const": "vonage"}}, "required": ["provider"]}, "then": {"required": ["api_key", "api_secret", "from"]}},
+		{"if": {"not": {"required": ["provider"]}}, "then": {"required": ["provider_api"]}},
+		{"if": {"properties": {"provider": {"const": "http"}}, "required": ["provider"]}, "then": {"required": ["provider_api"]}}
+	]
+}`
+
+func init() {
+	plugins.Register("sms", New)
+	plugins.RegisterSchema("sms", configSchema)
+}
+
+// Providers
+const (
+	ProviderTwilio = "twilio"
+	ProviderVonage = "vonage"
+	ProviderHTTP   = "http"
+)
+
+// How texts longer than one SMS are sent
+const (
+	// LongConcatenate sends the text at once and lets the provider join the segments on the phone
+	LongConcatenate = "concatenate"
+	// LongSplit sends each segment as a separate SMS, for providers that can't concatenate
+	LongSplit = "split"
+	// LongTruncate sends the first segment only
+	LongTruncate = "truncate"
+)
+
+const defaultMaxSegments = 10
+
+// e164 matches phone numbers in international format, e.g. +14155550123
+var e164 = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
+// provider sends one text to one number and returns the IDs the provider gave it
+type provider interface {
+	send(to, text, encoding string) ([]string, error)
+}
+
 // SMSNotifier struct for SMS notifications
 type SMSNotifier struct {
-	providerAPI string
-	apiKey      string
-	phoneNumber string
+	provider     provider
+	phoneNumber  string
+	longMessages string
+	maxSegments  int
 }
 
 // Name returns the name of the notifier
@@ -23,26 +91,132 @@ func (s *SMSNotifier) Type() string {
 	return "sms"
 }
 
-// Notify sends an SMS
+// Notify sends an SMS to the configured phone number
 func (s *SMSNotifier) Notify(message *config.Message) error {
-	fmt.Printf("Sending SMS to %s: %s\n", s.phoneNumber, message.Text)
-	// WIP
+	_, err := s.NotifyTracked(message, nil)
+	return err
+}
+
+// NotifyRecipients sends an SMS to each recipient, or to the configured phone number when there are none
+func (s *SMSNotifier) NotifyRecipients(message *config.Message, recipients []string) error {
+	_, err := s.NotifyTracked(message, recipients)
+	return err
+}
+
+// NotifyTracked sends an SMS to each recipient, or to the configured phone number when
+// there are none, and returns the provider message IDs
+func (s *SMSNotifier) NotifyTracked(message *config.Message, recipients []string) ([]string, error) {
+	recipients = config.RecipientsOrDefault(recipients, s.phoneNumber)
+	if len(recipients) == 0 {
+		return nil, config.Permanent(errors.New("no recipient and no default phone number configured"))
+	}
+	if err := s.ValidateRecipients(recipients); err != nil {
+		return nil, config.Permanent(err)
+	}
+
+	text := message.Text
+	if message.Title != "" {
+		text = strings.TrimSpace(message.Title + "\n" + message.Text)
+	}
+	if text == "" {
+		return nil, config.Permanent(errors.New("empty SMS text"))
+	}
+	enc, texts := s.texts(text)
+
+	var ids []string
+	for _, to := range recipients {
+		for i, part := range texts {
+			sent, err := s.provider.send(to, part, enc)
+			ids = append(ids, sent...)
+			if err != nil && i > 0 {
+				// A retry would send the first parts to the recipient again
+				return ids, config.Permanent(fmt.Errorf("sent %d of %d parts to %s: %w", i, len(texts), to, err))
+			}
+			if err != nil {
+				return ids, err
+			}
+		}
+	}
+	slog.Debug("Notification sent", "plugin", "sms", "recipients", len(recipients), "encoding", enc, "messages", len(texts))
+	return ids, nil
+}
+
+// ValidateRecipients checks that every recipient is a phone number in E.164 format
+func (s *SMSNotifier) ValidateRecipients(recipients []string) error {
+	for _, recipient := range recipients {
+		if !e164.MatchString(recipient) {
+			return fmt.Errorf("invalid phone number %q, expected E.164 format such as +14155550123", recipient)
+		}
+	}
 	return nil
 }
 
+// texts returns the encoding of the text and what is sent for it according to the long
+// message setting, never more than the maximum number of segments
+func (s *SMSNotifier) texts(text string) (string, []string) {
+	enc, parts := segments(text)
+	if len(parts) > s.maxSegments {
+		slog.Warn("SMS text is too long, truncating it", "segments", len(parts), "max_segments", s.maxSegments)
+		parts = parts[:s.maxSegments]
+	}
+
+	switch s.longMessages {
+	case LongSplit:
+		return enc, parts
+	case LongTruncate:
+		return enc, parts[:1]
+	default:
+		return enc, []string{strings.Join(parts, "")}
+	}
+}
+
+// do sends a provider request, keeping the URL out of errors since it may hold credentials
+func do(client *http.Client, req *http.Request) (*http.Response, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("failed to send SMS: %w", err)
+	}
+	return resp, nil
+}
+
 // New creates a new SMSNotifier instance
 func New(config map[string]interface{}) (config.Notifier, error) {
-	providerAPI, ok := config["provider_api"].(string)
-	apiKey, ok2 := config["api_key"].(string)
-	phoneNumber, ok3 := config["phone_number"].(string)
+	from, _ := config["from"].(string)
+	phoneNumber, _ := config["phone_number"].(string) // Optional, deliveries usually carry their recipients
+	if phoneNumber != "" && !e164.MatchString(phoneNumber) {
+		return nil, fmt.Errorf("invalid phone_number %q, expected E.164 format such as +14155550123", phoneNumber)
+	}
 
-	if !(ok && ok2 && ok3) {
-		return nil, errors.New("missing or invalid SMS configuration")
+	client := &http.Client{Timeout: 30 * time.Second}
+	var p provider
+	var err error
+	switch name, _ := config["provider"].(string); name {
+	case ProviderTwilio:
+		p, err = newTwilio(config, from, client)
+	case ProviderVonage:
+		p, err = newVonage(config, from, client)
+	case "", ProviderHTTP:
+		p, err = newHTTPProvider(config, from, client)
+	default:
+		err = fmt.Errorf("unknown SMS provider %q, expected twilio, vonage or http", name)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	return &SMSNotifier{
-		providerAPI: providerAPI,
-		apiKey:      apiKey,
-		phoneNumber: phoneNumber,
-	}, nil
+	notifier := &SMSNotifier{provider: p, phoneNumber: phoneNumber, longMessages: LongConcatenate, maxSegments: defaultMaxSegments}
+	if longMessages, _ := config["long_messages"].(string); longMessages != "" {
+		if longMessages != LongConcatenate && longMessages != LongSplit && longMessages != LongTruncate {
+			return nil, fmt.Errorf("invalid long_messages %q, expected concatenate, split or truncate", longMessages)
+		}
+		notifier.longMessages = longMessages
+	}
+	if maxSegments, ok := config["max_segments"].(int); ok && maxSegments > 0 {
+		notifier.maxSegments = maxSegments
+	}
+	return notifier, nil
 }
@@ -0,0 +1,420 @@
+package sms
+
+import (
+	"dynamic-notification-system/config"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// request is a provider API request received by the fake server
+type request struct {
+	method string
+	path   string
+	header http.Header
+	body   string
+}
+
+func (r request) form() url.Values {
+	form, _ := url.ParseQuery(r.body)
+	return form
+}
+
+// response is a scripted provider answer
+type response struct {
+	status int
+	body   string
+}
+
+// fakeProvider answers with the scripted responses, in order, then with the default one
+type fakeProvider struct {
+	mu        sync.Mutex
+	requests  []request
+	responses []response
+	fallback  response
+}
+
+func (f *fakeProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, request{method: r.Method, path: r.URL.Path, header: r.Header, body: string(body)})
+	resp := f.fallback
+	if len(f.responses) > 0 {
+		resp, f.responses = f.responses[0], f.responses[1:]
+	}
+	w.WriteHeader(resp.status)
+	w.Write([]byte(resp.body))
+}
+
+// newNotifier returns a notifier of the settings sending to a fake provider, which
+// answers with the fallback response unless scripted otherwise
+func newNotifier(t *testing.T, settings map[string]interface{}, fallback response) (*SMSNotifier, *fakeProvider) {
+	t.Helper()
+	api := &fakeProvider{fallback: fallback}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	settings["provider_api"] = server.URL
+	if settings["provider"] == ProviderHTTP {
+		settings["provider_api"] = server.URL + "/send"
+	}
+	notifier, err := New(settings)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return notifier.(*SMSNotifier), api
+}
+
+func twilioSettings(from string) map[string]interface{} {
+	return map[string]interface{}{"provider": ProviderTwilio, "account_sid": "AC123", "auth_token": "secret", "from": from}
+}
+
+func vonageSettings() map[string]interface{} {
+	return map[string]interface{}{"provider": ProviderVonage, "api_key": "key", "api_secret": "secret", "from": "+14155550100"}
+}
+
+func TestValidateRecipients(t *testing.T) {
+	tests := []struct {
+		number string
+		valid  bool
+	}{
+		{"+14155550123", true},
+		{"+447700900123", true},
+		{"+12", true},
+		{"+123456789012345", true},
+		{"+1234567890123456", false}, // 16 digits
+		{"+1", false},
+		{"14155550123", false},
+		{"+04155550123", false},
+		{"+1 415 555 0123", false},
+		{"+1-415-555-0123", false},
+		{"", false},
+	}
+	s := &SMSNotifier{}
+	for _, tt := range tests {
+		err := s.ValidateRecipients([]string{"+14155550100", tt.number})
+		if (err == nil) != tt.valid {
+			t.Errorf("ValidateRecipients(%q) = %v, want valid %v", tt.number, err, tt.valid)
+		}
+	}
+
+	if _, err := New(map[string]interface{}{"provider_api": "http://localhost", "phone_number": "0612345678"}); err == nil {
+		t.Errorf("New accepted a phone_number outside E.164")
+	}
+
+	// Invalid recipients are never sent to
+	notifier, api := newNotifier(t, twilioSettings("+14155550100"), response{status: http.StatusCreated, body: `{"sid": "SM1"}`})
+	err := notifier.NotifyRecipients(&config.Message{Text: "hello"}, []string{"+14155550123", "0612345678"})
+	if err == nil || config.IsRetryable(err) {
+		t.Errorf("NotifyRecipients = %v, want a permanent error", err)
+	}
+	if len(api.requests) != 0 {
+		t.Errorf("requests = %d, want none", len(api.requests))
+	}
+}
+
+func TestTwilio(t *testing.T) {
+	tests := []struct {
+		from     string
+		wantForm url.Values
+	}{
+		{"+14155550100", url.Values{"To": {"+14155550123"}, "Body": {"Deploy\nv1.2 is live"}, "From": {"+14155550100"}}},
+		{"MG0123456789", url.Values{"To": {"+14155550123"}, "Body": {"Deploy\nv1.2 is live"}, "MessagingServiceSid": {"MG0123456789"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from, func(t *testing.T) {
+			notifier, api := newNotifier(t, twilioSettings(tt.from), response{status: http.StatusCreated, body: `{"sid": "SM1"}`})
+			ids, err := notifier.NotifyTracked(&config.Message{Title: "Deploy", Text: "v1.2 is live"}, []string{"+14155550123"})
+			if err != nil {
+				t.Fatalf("NotifyTracked: %v", err)
+			}
+			if len(ids) != 1 || ids[0] != "SM1" {
+				t.Errorf("ids = %v, want [SM1]", ids)
+			}
+
+			req := api.requests[0]
+			if req.method != "POST" || req.path != "/2010-04-01/Accounts/AC123/Messages.json" {
+				t.Errorf("request = %s %s", req.method, req.path)
+			}
+			if user, password, ok := (&http.Request{Header: req.header}).BasicAuth(); !ok || user != "AC123" || password != "secret" {
+				t.Errorf("basic auth = %s:%s", user, password)
+			}
+			if got := req.form().Encode(); got != tt.wantForm.Encode() {
+				t.Errorf("form = %s, want %s", got, tt.wantForm.Encode())
+			}
+		})
+	}
+}
+
+func TestTwilioErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		response  response
+		message   string
+		retryable bool
+	}{
+		{"invalid number", response{http.StatusBadRequest, `{"code": 21211, "message": "The 'To' number is not a valid phone number."}`}, "21211", false},
+		{"rate limited", response{http.StatusTooManyRequests, `{"code": 20429, "message": "Too Many Requests"}`}, "Too Many Requests", true},
+		{"outage", response{http.StatusServiceUnavailable, "<html>Service Unavailable</html>"}, "503", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notifier, _ := newNotifier(t, twilioSettings("+14155550100"), tt.response)
+			err := notifier.NotifyRecipients(&config.Message{Text: "hello"}, []string{"+14155550123"})
+			if err == nil || !strings.Contains(err.Error(), tt.message) {
+				t.Fatalf("NotifyRecipients = %v, want an error mentioning %q", err, tt.message)
+			}
+			if config.IsRetryable(err) != tt.retryable {
+				t.Errorf("retryable = %v, want %v", config.IsRetryable(err), tt.retryable)
+			}
+		})
+	}
+
+	// An accepted message is sent even if its ID can't be read
+	notifier, api := newNotifier(t, twilioSettings("+14155550100"), response{status: http.StatusCreated, body: "<html>Created</html>"})
+	ids, err := notifier.NotifyTracked(&config.Message{Text: "hello"}, []string{"+14155550123"})
+	if err != nil || len(ids) != 0 || len(api.requests) != 1 {
+		t.Errorf("NotifyTracked = %v, %v after %d requests, want sent without an ID", ids, err, len(api.requests))
+	}
+}
+
+func TestVonage(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		wantType string
+	}{
+		{"GSM-7", "Disk almost full", ""},
+		{"unicode", "Диск почти заполнен", "unicode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notifier, api := newNotifier(t, vonageSettings(), response{status: http.StatusOK, body: `{"message-count": "1", "messages": [{"status": "0", "message-id": "V1"}]}`})
+			ids, err := notifier.NotifyTracked(&config.Message{Text: tt.text}, []string{"+14155550123"})
+			if err != nil || len(ids) != 1 || ids[0] != "V1" {
+				t.Fatalf("NotifyTracked = %v, %v", ids, err)
+			}
+			req := api.requests[0]
+			if req.path != "/sms/json" {
+				t.Errorf("path = %s", req.path)
+			}
+			// Numbers are sent without the plus
+			want := url.Values{"api_key": {"key"}, "api_secret": {"secret"}, "from": {"14155550100"}, "to": {"14155550123"}, "text": {tt.text}}
+			if tt.wantType != "" {
+				want.Set("type", tt.wantType)
+			}
+			if got := req.form().Encode(); got != want.Encode() {
+				t.Errorf("form = %s, want %s", got, want.Encode())
+			}
+		})
+	}
+}
+
+func TestVonageStatus(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		ids       []string
+		fails     bool
+		status    int // StatusError code expected, 0 for none
+		retryable bool
+	}{
+		{"sent in two parts", `{"messages": [{"status": "0", "message-id": "V1"}, {"status": "0", "message-id": "V2"}]}`, []string{"V1", "V2"}, false, 0, false},
+		{"throttled", `{"messages": [{"status": "1", "error-text": "Throttled"}]}`, nil, true, http.StatusTooManyRequests, true},
+		{"internal error", `{"messages": [{"status": "5", "error-text": "Internal Error"}]}`, nil, true, http.StatusBadGateway, true},
+		{"invalid credentials", `{"messages": [{"status": "4", "error-text": "Bad Credentials"}]}`, nil, true, 0, false},
+		{"partner quota", `{"messages": [{"status": "9", "error-text": "Partner quota violation"}]}`, nil, true, 0, false},
+		{"second part throttled", `{"messages": [{"status": "0", "message-id": "V1"}, {"status": "1", "error-text": "Throttled"}]}`, []string{"V1"}, true, http.StatusTooManyRequests, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notifier, _ := newNotifier(t, vonageSettings(), response{status: http.StatusOK, body: tt.body})
+			ids, err := notifier.NotifyTracked(&config.Message{Text: "hello"}, []string{"+14155550123"})
+			if strings.Join(ids, ",") != strings.Join(tt.ids, ",") {
+				t.Errorf("ids = %v, want %v", ids, tt.ids)
+			}
+			if (err != nil) != tt.fails {
+				t.Fatalf("NotifyTracked = %v, want failure %v", err, tt.fails)
+			}
+			if err == nil {
+				return
+			}
+			var status *config.StatusError
+			if tt.status != 0 && (!errors.As(err, &status) || status.StatusCode != tt.status) {
+				t.Errorf("error = %v, want status %d", err, tt.status)
+			}
+			if config.IsRetryable(err) != tt.retryable {
+				t.Errorf("retryable = %v, want %v", config.IsRetryable(err), tt.retryable)
+			}
+		})
+	}
+}
+
+func TestHTTPProvider(t *testing.T) {
+	tests := []struct {
+		name       string
+		settings   map[string]interface{}
+		text       string
+		wantMethod string
+		wantBody   string
+		wantHeader map[string]string
+	}{
+		{
+			name:       "default body",
+			settings:   map[string]interface{}{"from": "Alerts", "api_key": "key"},
+			text:       `Disk "var" full`,
+			wantMethod: "POST",
+			wantBody:   `{"to": "+14155550123", "from": "Alerts", "text": "Disk \"var\" full"}`,
+			wantHeader: map[string]string{"Authorization": "Bearer key", "Content-Type": "application/json"},
+		},
+		{
+			name: "templates",
+			settings: map[string]interface{}{
+				"from":         "Alerts",
+				"api_key":      "key",
+				"method":       "put",
+				"content_type": "application/x-www-form-urlencoded",
+				"body":         "dest={{.To}}&msg={{.Text}}&coding={{.Encoding}}",
+				"headers":      map[string]interface{}{"X-Api-Key": "{{.APIKey}}"},
+			},
+			text:       "Диск",
+			wantMethod: "PUT",
+			wantBody:   "dest=+14155550123&msg=Диск&coding=UCS-2",
+			wantHeader: map[string]string{"X-Api-Key": "key", "Content-Type": "application/x-www-form-urlencoded", "Authorization": ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.settings["provider"] = ProviderHTTP
+			notifier, api := newNotifier(t, tt.settings, response{status: http.StatusOK, body: `{"ok": true}`})
+			ids, err := notifier.NotifyTracked(&config.Message{Text: tt.text}, []string{"+14155550123"})
+			if err != nil || len(ids) != 0 {
+				t.Fatalf("NotifyTracked = %v, %v, want sent without an ID", ids, err)
+			}
+			req := api.requests[0]
+			if req.method != tt.wantMethod || req.path != "/send" {
+				t.Errorf("request = %s %s, want %s /send", req.method, req.path, tt.wantMethod)
+			}
+			if req.body != tt.wantBody {
+				t.Errorf("body = %s, want %s", req.body, tt.wantBody)
+			}
+			for name, want := range tt.wantHeader {
+				if got := req.header.Get(name); got != want {
+					t.Errorf("header %s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPProviderResponse(t *testing.T) {
+	tests := []struct {
+		name          string
+		messageIDPath string
+		response      response
+		ids           []string
+		retryable     bool
+		fails         bool
+	}{
+		{"message ID", "messages.0.id", response{http.StatusOK, `{"messages": [{"id": "abc"}]}`}, []string{"abc"}, false, false},
+		{"numeric ID", "id", response{http.StatusAccepted, `{"id": 12345678901}`}, []string{"12345678901"}, false, false},
+		{"missing ID", "messages.1.id", response{http.StatusOK, `{"messages": [{"id": "abc"}]}`}, nil, false, false},
+		// Accepted, so not sent again because its ID can't be read
+		{"unreadable response", "messages.0.id", response{http.StatusOK, "queued"}, nil, false, false},
+		{"rejected", "", response{http.StatusBadRequest, "invalid destination\n"}, nil, false, true},
+		{"outage", "", response{http.StatusBadGateway, "bad gateway"}, nil, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := map[string]interface{}{"provider": ProviderHTTP}
+			if tt.messageIDPath != "" {
+				settings["message_id_path"] = tt.messageIDPath
+			}
+			notifier, _ := newNotifier(t, settings, tt.response)
+			ids, err := notifier.NotifyTracked(&config.Message{Text: "hello"}, []string{"+14155550123"})
+			if strings.Join(ids, ",") != strings.Join(tt.ids, ",") {
+				t.Errorf("ids = %v, want %v", ids, tt.ids)
+			}
+			if (err != nil) != tt.fails {
+				t.Fatalf("NotifyTracked = %v, want failure %v", err, tt.fails)
+			}
+			if err == nil {
+				return
+			}
+			if !strings.Contains(err.Error(), strings.TrimSpace(tt.response.body)) {
+				t.Errorf("error %q doesn't include the response", err)
+			}
+			if config.IsRetryable(err) != tt.retryable {
+				t.Errorf("retryable = %v, want %v", config.IsRetryable(err), tt.retryable)
+			}
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	value := map[string]interface{}{
+		"id":       "top",
+		"count":    float64(2),
+		"messages": []interface{}{map[string]interface{}{"id": "first"}, map[string]interface{}{"id": float64(42)}},
+	}
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"id", "top"},
+		{"count", "2"},
+		{"messages.0.id", "first"},
+		{"messages.1.id", "42"},
+		{"messages.2.id", ""},
+		{"messages.x.id", ""},
+		{"messages.-1.id", ""},
+		{"messages", ""},
+		{"id.nested", ""},
+		{"missing", ""},
+	}
+	for _, tt := range tests {
+		if got := lookup(value, tt.path); got != tt.want {
+			t.Errorf("lookup(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestSplitPartialFailure(t *testing.T) {
+	long := strings.Repeat("a", 153) + strings.Repeat("b", 153) + strings.Repeat("c", 10)
+	message := &config.Message{Text: long}
+	settings := func() map[string]interface{} {
+		return map[string]interface{}{"provider": ProviderHTTP, "long_messages": LongSplit, "message_id_path": "id"}
+	}
+	ok := response{http.StatusOK, `{"id": "part"}`}
+	outage := response{http.StatusServiceUnavailable, "unavailable"}
+
+	notifier, api := newNotifier(t, settings(), ok)
+	ids, err := notifier.NotifyTracked(message, []string{"+14155550123"})
+	if err != nil || len(ids) != 3 || len(api.requests) != 3 {
+		t.Fatalf("NotifyTracked = %v, %v after %d requests, want three parts", ids, err, len(api.requests))
+	}
+
+	// Nothing was sent yet, the delivery can be retried
+	notifier, api = newNotifier(t, settings(), ok)
+	api.responses = []response{outage}
+	if _, err := notifier.NotifyTracked(message, []string{"+14155550123"}); err == nil || !config.IsRetryable(err) {
+		t.Errorf("failure on the first part = %v, want a retryable error", err)
+	}
+
+	// A retry would send the first part again
+	notifier, api = newNotifier(t, settings(), ok)
+	api.responses = []response{ok, outage}
+	ids, err = notifier.NotifyTracked(message, []string{"+14155550123"})
+	if err == nil || config.IsRetryable(err) || !strings.Contains(err.Error(), "sent 1 of 3 parts") {
+		t.Errorf("failure on the second part = %v, want a permanent error", err)
+	}
+	if len(ids) != 1 || len(api.requests) != 2 {
+		t.Errorf("ids = %v after %d requests, want the first part's", ids, len(api.requests))
+	}
+}
@@ -0,0 +1,84 @@
+package sms
+
+import (
+	"dynamic-notification-system/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const twilioBaseURL = "https://api.twilio.com"
+
+// twilio sends through the Twilio Messages API, or any API compatible with it
+type twilio struct {
+	baseURL    string
+	accountSID string
+	authToken  string
+	from       string
+	client     *http.Client
+}
+
+func newTwilio(settings map[string]interface{}, from string, client *http.Client) (*twilio, error) {
+	accountSID, _ := settings["account_sid"].(string)
+	authToken, _ := settings["auth_token"].(string)
+	if accountSID == "" || authToken == "" || from == "" {
+		return nil, errors.New("twilio needs account_sid, auth_token and from")
+	}
+	baseURL, _ := settings["provider_api"].(string)
+	if baseURL == "" {
+		baseURL = twilioBaseURL
+	}
+	return &twilio{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		accountSID: accountSID,
+		authToken:  authToken,
+		from:       from,
+		client:     client,
+	}, nil
+}
+
+func (t *twilio) send(to, text, _ string) ([]string, error) {
+	form := url.Values{"To": {to}, "Body": {text}}
+	// Messaging service SIDs pick the sender from a pool
+	if strings.HasPrefix(t.from, "MG") {
+		form.Set("MessagingServiceSid", t.from)
+	} else {
+		form.Set("From", t.from)
+	}
+
+	req, err := http.NewRequest("POST", t.baseURL+"/2010-04-01/Accounts/"+url.PathEscape(t.accountSID)+"/Messages.json", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(t.accountSID, t.authToken)
+
+	resp, err := do(t.client, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		SID     string `json:"sid"`
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	decodeErr := json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		if decodeErr == nil && result.Message != "" {
+			return nil, fmt.Errorf("twilio error %d: %s, %w", result.Code, result.Message, config.NewStatusError(resp))
+		}
+		return nil, fmt.Errorf("twilio request failed, %w", config.NewStatusError(resp))
+	}
+	if decodeErr != nil {
+		// The message was accepted, failing here would have it sent again
+		slog.Warn("Failed to decode twilio response, message ID unknown", "plugin", "sms", "error", decodeErr)
+		return nil, nil
+	}
+	return []string{result.SID}, nil
+}
@@ -0,0 +1,96 @@
+package sms
+
+import (
+	"dynamic-notification-system/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const vonageBaseURL = "https://rest.nexmo.com"
+
+// vonage sends through the Vonage SMS API
+type vonage struct {
+	baseURL   string
+	apiKey    string
+	apiSecret string
+	from      string
+	client    *http.Client
+}
+
+func newVonage(settings map[string]interface{}, from string, client *http.Client) (*vonage, error) {
+	apiKey, _ := settings["api_key"].(string)
+	apiSecret, _ := settings["api_secret"].(string)
+	if apiKey == "" || apiSecret == "" || from == "" {
+		return nil, errors.New("vonage needs api_key, api_secret and from")
+	}
+	baseURL, _ := settings["provider_api"].(string)
+	if baseURL == "" {
+		baseURL = vonageBaseURL
+	}
+	return &vonage{
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		apiKey:    apiKey,
+		apiSecret: apiSecret,
+		from:      strings.TrimPrefix(from, "+"),
+		client:    client,
+	}, nil
+}
+
+func (v *vonage) send(to, text, enc string) ([]string, error) {
+	form := url.Values{
+		"api_key":    {v.apiKey},
+		"api_secret": {v.apiSecret},
+		"from":       {v.from},
+		"to":         {strings.TrimPrefix(to, "+")}, // Vonage wants the number without the plus
+		"text":       {text},
+	}
+	if enc == EncodingUCS2 {
+		form.Set("type", "unicode")
+	}
+
+	req, err := http.NewRequest("POST", v.baseURL+"/sms/json", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := do(v.client, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("vonage request failed, %w", config.NewStatusError(resp))
+	}
+
+	// Vonage answers 200 and reports each part of the message on its own
+	var result struct {
+		Messages []struct {
+			Status    string `json:"status"`
+			MessageID string `json:"message-id"`
+			ErrorText string `json:"error-text"`
+		} `json:"messages"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode vonage response: %w", err)
+	}
+
+	var ids []string
+	for _, m := range result.Messages {
+		switch m.Status {
+		case "0":
+			ids = append(ids, m.MessageID)
+		case "1": // Throttled
+			return ids, fmt.Errorf("vonage error: %s, %w", m.ErrorText, &config.StatusError{StatusCode: http.StatusTooManyRequests})
+		case "5": // Internal error
+			return ids, fmt.Errorf("vonage error: %s, %w", m.ErrorText, &config.StatusError{StatusCode: http.StatusBadGateway})
+		default:
+			return ids, config.Permanent(fmt.Errorf("vonage error %s: %s", m.Status, m.ErrorText))
+		}
+	}
+	return ids, nil
+}
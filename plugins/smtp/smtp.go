@@ -1,12 +1,34 @@
-package main
+package smtp
 
 import (
 	"dynamic-notification-system/config"
+	"dynamic-notification-system/plugins"
 	"errors"
 	"fmt"
+	"log/slog"
+	"net/mail"
 	"net/smtp"
+	"strconv"
 )
 
+// configSchema describes the channel settings
+const configSchema = `{
+	"type": "object",
+	"required": ["host", "port", "username", "password"],
+	"properties": {
+		"host": {"type": "string", "minLength": 1, "description": "SMTP server host"},
+		"port": {"type": ["string", "integer"], "description": "SMTP server port"},
+		"username": {"type": "string", "minLength": 1, "description": "SMTP user, also used as sender"},
+		"password": {"type": "string", "minLength": 1, "secret": true, "description": "SMTP password"},
+		"to": {"type": "string", "minLength": 1, "description": "Address used when a delivery has no recipient"}
+	}
+}`
+
+func init() {
+	plugins.Register("smtp", New)
+	plugins.RegisterSchema("smtp", configSchema)
+}
+
 // SMTPNotifier struct for sending emails
 type SMTPNotifier struct {
 	host     string
@@ -26,10 +48,28 @@ func (s *SMTPNotifier) Type() string {
 	return "smtp"
 }
 
-// Notify sends an email
+// Notify sends an email to the configured address
 func (s *SMTPNotifier) Notify(message *config.Message) error {
+	return s.NotifyRecipients(message, nil)
+}
+
+// NotifyRecipients sends an email to the recipients, or to the configured address when there are none
+func (s *SMTPNotifier) NotifyRecipients(message *config.Message, recipients []string) error {
+	recipients = config.RecipientsOrDefault(recipients, s.to)
+	if len(recipients) == 0 {
+		return config.Permanent(errors.New("no recipient and no default to address configured"))
+	}
+	// SMTP wants bare addresses, recipients may be written as "Name <address>"
+	to := make([]string, 0, len(recipients))
+	for _, recipient := range recipients {
+		address, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return config.Permanent(fmt.Errorf("invalid email address %q", recipient))
+		}
+		to = append(to, address.Address)
+	}
+
 	auth := smtp.PlainAuth("", s.username, s.password, s.host)
-	to := []string{s.to}
 	msg := []byte(fmt.Sprintf("Subject: Notification\n\n%s", message.Text))
 	addr := fmt.Sprintf("%s:%s", s.host, s.port)
 
@@ -38,19 +78,33 @@ func (s *SMTPNotifier) Notify(message *config.Message) error {
 		return fmt.Errorf("failed to send email: %w", err)
 	}
 
-	fmt.Println("Notification sent via SMTP successfully")
+	slog.Debug("Notification sent", "plugin", "smtp", "recipients", len(to))
+	return nil
+}
+
+// ValidateRecipients checks that every recipient is an email address
+func (s *SMTPNotifier) ValidateRecipients(recipients []string) error {
+	for _, recipient := range recipients {
+		if _, err := mail.ParseAddress(recipient); err != nil {
+			return fmt.Errorf("invalid email address %q", recipient)
+		}
+	}
 	return nil
 }
 
 // New creates a new SMTPNotifier instance
 func New(config map[string]interface{}) (config.Notifier, error) {
 	host, ok := config["host"].(string)
+	// YAML reads an unquoted port as a number
 	port, ok2 := config["port"].(string)
+	if n, isInt := config["port"].(int); isInt {
+		port, ok2 = strconv.Itoa(n), true
+	}
 	username, ok3 := config["username"].(string)
 	password, ok4 := config["password"].(string)
-	to, ok5 := config["to"].(string)
+	to, _ := config["to"].(string) // Optional, deliveries usually carry their recipients
 
-	if !(ok && ok2 && ok3 && ok4 && ok5) {
+	if !(ok && ok2 && ok3 && ok4) {
 		return nil, errors.New("missing or invalid SMTP configuration")
 	}
 
@@ -1,14 +1,29 @@
-package main
+package teams
 
 import (
-	"bytes"
 	"dynamic-notification-system/config"
+	"dynamic-notification-system/plugins"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 )
 
+// configSchema describes the channel settings
+const configSchema = `{
+	"type": "object",
+	"required": ["webhook_url"],
+	"properties": {
+		"webhook_url": {"type": "string", "minLength": 1, "secret": true, "description": "Teams incoming webhook URL"}
+	}
+}`
+
+func init() {
+	plugins.Register("teams", New)
+	plugins.RegisterSchema("teams", configSchema)
+}
+
 // TeamsNotifier struct for the Microsoft Teams channel
 type TeamsNotifier struct {
 	webhookURL string
@@ -39,17 +54,17 @@ func (t *TeamsNotifier) Notify(message *config.Message) error {
 		return fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
-	resp, err := http.Post(t.webhookURL, "application/json", bytes.NewBuffer(jsonPayload))
+	resp, err := plugins.PostJSON(t.webhookURL, jsonPayload)
 	if err != nil {
 		return fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to send notification, received status code: %d", resp.StatusCode)
+		return fmt.Errorf("failed to send notification, %w", config.NewStatusError(resp))
 	}
 
-	fmt.Println("Notification sent to Teams successfully")
+	slog.Debug("Notification sent", "plugin", "teams")
 	return nil
 }
 
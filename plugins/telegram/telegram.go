@@ -1,33 +1,336 @@
-package main
+package telegram
 
 import (
+	"bytes"
 	"dynamic-notification-system/config"
+	"dynamic-notification-system/plugins"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// configSchema describes the channel settings
+const configSchema = `{
+	"type": "object",
+	"required": ["api_key"],
+	"properties": {
+		"api_key": {"type": "string", "minLength": 1, "secret": true, "description": "Bot API token"},
+		"chat_id": {"type": ["string", "integer"], "description": "Chat used when a delivery has no recipient"},
+		"parse_mode": {"enum": ["", "MarkdownV2", "HTML"], "description": "How the message text is formatted, plain text by default"},
+		"base_url": {"type": "string", "minLength": 1, "description": "Bot API URL, defaults to https://api.telegram.org"}
+	}
+}`
+
+func init() {
+	plugins.Register("telegram", New)
+	plugins.RegisterSchema("telegram", configSchema)
+}
+
+const (
+	defaultBaseURL = "https://api.telegram.org"
+	// maxCaption is the longest caption Telegram accepts with a photo or document
+	maxCaption = 1024
+	// maxInlineWait is the longest retry_after waited for in place. Longer waits are left to
+	// the delivery queue, so a worker isn't held up.
+	maxInlineWait = 5 * time.Second
+)
+
+// Parse modes
+const (
+	ParseModeMarkdownV2 = "MarkdownV2"
+	ParseModeHTML       = "HTML"
+)
+
+// photoExtensions are the attachments sent with sendPhoto, anything else goes through sendDocument
+var photoExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".webp": true}
+
+// TelegramNotifier sends messages through the Telegram Bot API
 type TelegramNotifier struct {
-	apiKey string
+	apiKey    string
+	chatID    string
+	parseMode string
+	baseURL   string
+	client    *http.Client
 }
 
+// Name returns the name of the notifier
 func (t *TelegramNotifier) Name() string {
 	return "Telegram"
 }
 
+// Type returns the type of the notifier
 func (t *TelegramNotifier) Type() string {
 	return "telegram"
 }
 
+// Notify sends the message to the configured chat
 func (t *TelegramNotifier) Notify(message *config.Message) error {
-	// WIP
-	fmt.Printf("Sending message to Telegram: %s\n", message.Text)
+	return t.NotifyRecipients(message, nil)
+}
+
+// NotifyRecipients sends the message to every recipient chat, or to the configured chat
+// when there are none
+func (t *TelegramNotifier) NotifyRecipients(message *config.Message, recipients []string) error {
+	chats := config.RecipientsOrDefault(recipients, t.chatID)
+	if len(chats) == 0 {
+		return config.Permanent(errors.New("no recipient and no default chat_id configured"))
+	}
+	if err := t.ValidateRecipients(chats); err != nil {
+		return config.Permanent(err)
+	}
+	if err := t.ValidateMessage(message); err != nil {
+		return config.Permanent(err)
+	}
+
+	for _, chat := range chats {
+		if err := t.send(message, chat); err != nil {
+			return err
+		}
+	}
+	slog.Debug("Notification sent", "plugin", "telegram", "chats", len(chats))
+	return nil
+}
+
+// ValidateRecipients checks that every recipient is a chat ID or a @channel username
+func (t *TelegramNotifier) ValidateRecipients(recipients []string) error {
+	for _, recipient := range recipients {
+		if _, err := strconv.ParseInt(recipient, 10, 64); err == nil {
+			continue
+		}
+		if strings.HasPrefix(recipient, "@") && len(recipient) > 1 {
+			continue
+		}
+		return fmt.Errorf("invalid Telegram chat %q, expected a chat ID or @channelusername", recipient)
+	}
 	return nil
 }
 
+// ValidateMessage checks that the actions can be shown as inline keyboard buttons
+func (t *TelegramNotifier) ValidateMessage(message *config.Message) error {
+	_, err := keyboard(message.Actions)
+	return err
+}
+
+// send delivers the message to one chat: the attachment with the text as caption when it
+// fits, otherwise the text followed by the attachment.
+func (t *TelegramNotifier) send(message *config.Message, chat string) error {
+	markup, err := keyboard(message.Actions)
+	if err != nil {
+		return config.Permanent(err)
+	}
+	text := t.text(message)
+
+	params := func() map[string]interface{} {
+		p := map[string]interface{}{"chat_id": chat}
+		// Low priority messages arrive without a sound
+		if message.Priority > 0 && message.Priority <= 2 {
+			p["disable_notification"] = true
+		}
+		return p
+	}
+
+	if message.Attach != "" && len([]rune(text)) > maxCaption {
+		textOnly := params()
+		t.setText(textOnly, "text", text)
+		if err := t.call("sendMessage", textOnly); err != nil {
+			return err
+		}
+		text = ""
+	}
+
+	method, field := "sendMessage", "text"
+	request := params()
+	if message.Attach != "" {
+		method, field = "sendDocument", "caption"
+		request["document"] = message.Attach
+		if photoExtensions[strings.ToLower(path.Ext(attachmentPath(message.Attach)))] {
+			method = "sendPhoto"
+			delete(request, "document")
+			request["photo"] = message.Attach
+		}
+	}
+	t.setText(request, field, text)
+	if markup != nil {
+		request["reply_markup"] = markup
+	}
+	return t.call(method, request)
+}
+
+// setText adds the text, or caption, along with the parse mode it is written in
+func (t *TelegramNotifier) setText(request map[string]interface{}, field, text string) {
+	if text == "" {
+		return
+	}
+	request[field] = text
+	if t.parseMode != "" {
+		request["parse_mode"] = t.parseMode
+	}
+}
+
+// text joins the title, in bold when a parse mode is set, and the message text
+func (t *TelegramNotifier) text(message *config.Message) string {
+	if message.Title == "" {
+		return message.Text
+	}
+	title := message.Title
+	switch t.parseMode {
+	case ParseModeMarkdownV2:
+		title = "*" + escapeMarkdownV2(title) + "*"
+	case ParseModeHTML:
+		title = "<b>" + html.EscapeString(title) + "</b>"
+	}
+	if message.Text == "" {
+		return title
+	}
+	return title + "\n\n" + message.Text
+}
+
+// apiResponse is the envelope of every Bot API response
+type apiResponse struct {
+	OK          bool   `json:"ok"`
+	ErrorCode   int    `json:"error_code"`
+	Description string `json:"description"`
+	Parameters  struct {
+		RetryAfter      int   `json:"retry_after"`
+		MigrateToChatID int64 `json:"migrate_to_chat_id"`
+	} `json:"parameters"`
+}
+
+// call invokes a Bot API method, waiting once in place when Telegram asks for a short pause.
+func (t *TelegramNotifier) call(method string, params map[string]interface{}) error {
+	err := t.post(method, params)
+	var status *config.StatusError
+	if errors.As(err, &status) && status.StatusCode == http.StatusTooManyRequests && status.RetryAfter <= maxInlineWait {
+		time.Sleep(status.RetryAfter)
+		err = t.post(method, params)
+	}
+	return err
+}
+
+func (t *TelegramNotifier) post(method string, params map[string]interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s request: %w", method, err)
+	}
+
+	resp, err := t.client.Post(t.baseURL+"/bot"+t.apiKey+"/"+method, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// The request URL holds the bot token, keep it out of the error
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("failed to call %s: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	var result apiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("%s failed, %w", method, config.NewStatusError(resp))
+		}
+		return fmt.Errorf("failed to decode %s response: %w", method, err)
+	}
+	if result.OK {
+		return nil
+	}
+
+	statusErr := config.NewStatusError(resp)
+	if result.ErrorCode != 0 {
+		statusErr.StatusCode = result.ErrorCode
+	}
+	if result.Parameters.RetryAfter > 0 {
+		statusErr.RetryAfter = time.Duration(result.Parameters.RetryAfter) * time.Second
+	}
+	if result.Parameters.MigrateToChatID != 0 {
+		return fmt.Errorf("%s failed: %s, the group is now chat %d, %w", method, result.Description, result.Parameters.MigrateToChatID, statusErr)
+	}
+	return fmt.Errorf("%s failed: %s, %w", method, result.Description, statusErr)
+}
+
+// keyboard maps view actions, {"action": "view", "label": "...", "url": "..."}, to an inline
+// keyboard with one URL button per row
+func keyboard(actions []interface{}) (map[string]interface{}, error) {
+	if len(actions) == 0 {
+		return nil, nil
+	}
+
+	rows := make([][]map[string]string, 0, len(actions))
+	for i, action := range actions {
+		fields, ok := action.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("action %d: expected an object with a label and a url", i+1)
+		}
+		kind, _ := fields["action"].(string)
+		label, _ := fields["label"].(string)
+		link, _ := fields["url"].(string)
+		if kind != "" && kind != "view" {
+			return nil, fmt.Errorf("action %d: telegram only supports view actions, got %q", i+1, kind)
+		}
+		if label == "" || link == "" {
+			return nil, fmt.Errorf("action %d: label and url are required", i+1)
+		}
+		rows = append(rows, []map[string]string{{"text": label, "url": link}})
+	}
+	return map[string]interface{}{"inline_keyboard": rows}, nil
+}
+
+// attachmentPath returns the path of an attachment URL, ignoring its query string
+func attachmentPath(attach string) string {
+	if u, err := url.Parse(attach); err == nil {
+		return u.Path
+	}
+	return attach
+}
+
+// escapeMarkdownV2 escapes the characters MarkdownV2 reserves
+func escapeMarkdownV2(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune("_*[]()~`>#+-=|{}.!\\", r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// New creates a new TelegramNotifier instance
 func New(config map[string]interface{}) (config.Notifier, error) {
 	apiKey, ok := config["api_key"].(string)
 	if !ok || apiKey == "" {
 		return nil, errors.New("missing or invalid API key")
 	}
-	return &TelegramNotifier{apiKey: apiKey}, nil
+
+	// Chat IDs are numbers, YAML reads them unquoted as int
+	chatID, _ := config["chat_id"].(string)
+	if n, isInt := config["chat_id"].(int); isInt {
+		chatID = strconv.Itoa(n)
+	}
+
+	parseMode, _ := config["parse_mode"].(string)
+	if parseMode != "" && parseMode != ParseModeMarkdownV2 && parseMode != ParseModeHTML {
+		return nil, fmt.Errorf("invalid parse_mode %q, expected MarkdownV2 or HTML", parseMode)
+	}
+
+	baseURL, _ := config["base_url"].(string)
+	if baseURL == "" {
+		baseURL = defaultBaseURL
+	}
+
+	return &TelegramNotifier{
+		apiKey:    apiKey,
+		chatID:    chatID,
+		parseMode: parseMode,
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}, nil
 }
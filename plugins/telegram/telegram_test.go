@@ -0,0 +1,230 @@
+package telegram
+
+import (
+	"dynamic-notification-system/config"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// call is a Bot API request received by the fake server
+type call struct {
+	method string
+	params map[string]interface{}
+}
+
+// fakeAPI answers Bot API calls with the scripted responses, in order, then with ok
+type fakeAPI struct {
+	t         *testing.T
+	mu        sync.Mutex
+	calls     []call
+	responses []string
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method, ok := strings.CutPrefix(r.URL.Path, "/bottoken/")
+	if !ok {
+		f.t.Errorf("unexpected path %s", r.URL.Path)
+	}
+	var params map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		f.t.Errorf("decoding %s request: %v", method, err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, call{method: method, params: params})
+	response := `{"ok": true, "result": {}}`
+	if len(f.responses) > 0 {
+		response, f.responses = f.responses[0], f.responses[1:]
+	}
+	if strings.Contains(response, `"error_code": 429`) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}
+	w.Write([]byte(response))
+}
+
+func newNotifier(t *testing.T, settings map[string]interface{}, responses ...string) (*TelegramNotifier, *fakeAPI) {
+	t.Helper()
+	api := &fakeAPI{t: t, responses: responses}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	settings["api_key"] = "token"
+	settings["base_url"] = server.URL
+	notifier, err := New(settings)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return notifier.(*TelegramNotifier), api
+}
+
+func TestSendMessage(t *testing.T) {
+	tests := []struct {
+		name      string
+		parseMode string
+		message   config.Message
+		want      map[string]interface{}
+	}{
+		{
+			name:    "plain text",
+			message: config.Message{Title: "Deploy", Text: "v1.2 is live"},
+			want:    map[string]interface{}{"chat_id": "42", "text": "Deploy\n\nv1.2 is live"},
+		},
+		{
+			name:      "HTML",
+			parseMode: ParseModeHTML,
+			message:   config.Message{Title: "Build <main>", Text: "<i>green</i>"},
+			want:      map[string]interface{}{"chat_id": "42", "text": "<b>Build &lt;main&gt;</b>\n\n<i>green</i>", "parse_mode": "HTML"},
+		},
+		{
+			name:      "MarkdownV2",
+			parseMode: ParseModeMarkdownV2,
+			message:   config.Message{Title: "v1.2 (beta)", Text: "_done_"},
+			want:      map[string]interface{}{"chat_id": "42", "text": "*v1\\.2 \\(beta\\)*\n\n_done_", "parse_mode": "MarkdownV2"},
+		},
+		{
+			name:    "low priority",
+			message: config.Message{Text: "FYI", Priority: 2},
+			want:    map[string]interface{}{"chat_id": "42", "text": "FYI", "disable_notification": true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notifier, api := newNotifier(t, map[string]interface{}{"chat_id": 42, "parse_mode": tt.parseMode})
+			if err := notifier.Notify(&tt.message); err != nil {
+				t.Fatalf("Notify: %v", err)
+			}
+			if len(api.calls) != 1 || api.calls[0].method != "sendMessage" {
+				t.Fatalf("calls = %+v, want a single sendMessage", api.calls)
+			}
+			checkParams(t, api.calls[0].params, tt.want)
+		})
+	}
+}
+
+func TestAttachment(t *testing.T) {
+	tests := []struct {
+		attach string
+		method string
+		field  string
+	}{
+		{"https://example.com/graph.png", "sendPhoto", "photo"},
+		{"https://example.com/photo.JPEG?size=large", "sendPhoto", "photo"},
+		{"https://example.com/report.pdf", "sendDocument", "document"},
+		{"https://example.com/download?file=graph.png", "sendDocument", "document"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.attach, func(t *testing.T) {
+			notifier, api := newNotifier(t, map[string]interface{}{})
+			message := &config.Message{Text: "Weekly report", Attach: tt.attach}
+			if err := notifier.NotifyRecipients(message, []string{"@ops"}); err != nil {
+				t.Fatalf("NotifyRecipients: %v", err)
+			}
+			if len(api.calls) != 1 || api.calls[0].method != tt.method {
+				t.Fatalf("calls = %+v, want a single %s", api.calls, tt.method)
+			}
+			checkParams(t, api.calls[0].params, map[string]interface{}{"chat_id": "@ops", tt.field: tt.attach, "caption": "Weekly report"})
+		})
+	}
+}
+
+func TestCaptionOverflow(t *testing.T) {
+	notifier, api := newNotifier(t, map[string]interface{}{"chat_id": "42"})
+	// Counted in characters, not bytes
+	text := strings.Repeat("é", maxCaption+1)
+	if err := notifier.Notify(&config.Message{Text: text, Attach: "https://example.com/graph.png"}); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if len(api.calls) != 2 || api.calls[0].method != "sendMessage" || api.calls[1].method != "sendPhoto" {
+		t.Fatalf("calls = %+v, want sendMessage then sendPhoto", api.calls)
+	}
+	checkParams(t, api.calls[0].params, map[string]interface{}{"chat_id": "42", "text": text})
+	checkParams(t, api.calls[1].params, map[string]interface{}{"chat_id": "42", "photo": "https://example.com/graph.png"})
+
+	// A caption that fits is sent with the photo
+	api.calls = nil
+	text = strings.Repeat("é", maxCaption)
+	if err := notifier.Notify(&config.Message{Text: text, Attach: "https://example.com/graph.png"}); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if len(api.calls) != 1 || api.calls[0].params["caption"] != text {
+		t.Errorf("calls = %d, want the caption on the photo", len(api.calls))
+	}
+}
+
+func TestInlineKeyboard(t *testing.T) {
+	notifier, api := newNotifier(t, map[string]interface{}{"chat_id": "42"})
+	message := &config.Message{Text: "Disk almost full", Actions: []interface{}{
+		map[string]interface{}{"action": "view", "label": "Dashboard", "url": "https://example.com/dash"},
+		map[string]interface{}{"label": "Runbook", "url": "https://example.com/runbook"},
+	}}
+	if err := notifier.Notify(message); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	want := map[string]interface{}{"inline_keyboard": []interface{}{
+		[]interface{}{map[string]interface{}{"text": "Dashboard", "url": "https://example.com/dash"}},
+		[]interface{}{map[string]interface{}{"text": "Runbook", "url": "https://example.com/runbook"}},
+	}}
+	checkParams(t, api.calls[0].params, map[string]interface{}{"chat_id": "42", "text": "Disk almost full", "reply_markup": want})
+
+	// Telegram buttons only open links
+	api.calls = nil
+	message.Actions = []interface{}{map[string]interface{}{"action": "http", "label": "Ack", "url": "https://example.com/ack"}}
+	err := notifier.Notify(message)
+	if err == nil || config.IsRetryable(err) {
+		t.Errorf("Notify with an http action = %v, want a permanent error", err)
+	}
+	if len(api.calls) != 0 {
+		t.Errorf("calls = %+v, want none", api.calls)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	// A short pause is waited for in place
+	notifier, api := newNotifier(t, map[string]interface{}{"chat_id": "42"},
+		`{"ok": false, "error_code": 429, "description": "Too Many Requests: retry after 1", "parameters": {"retry_after": 1}}`)
+	start := time.Now()
+	if err := notifier.Notify(&config.Message{Text: "hello"}); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if len(api.calls) != 2 {
+		t.Errorf("calls = %d, want the request sent again", len(api.calls))
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want retry_after respected", elapsed)
+	}
+
+	// A long one is left to the delivery queue
+	notifier, api = newNotifier(t, map[string]interface{}{"chat_id": "42"},
+		`{"ok": false, "error_code": 429, "description": "Too Many Requests: retry after 30", "parameters": {"retry_after": 30}}`)
+	err := notifier.Notify(&config.Message{Text: "hello"})
+	var status *config.StatusError
+	if !errors.As(err, &status) || status.StatusCode != http.StatusTooManyRequests || status.RetryAfter != 30*time.Second {
+		t.Fatalf("Notify = %v, want a 429 StatusError with the retry_after", err)
+	}
+	if !config.IsRetryable(err) {
+		t.Errorf("rate limit error is not retryable")
+	}
+	if len(api.calls) != 1 {
+		t.Errorf("calls = %d, want a single attempt", len(api.calls))
+	}
+}
+
+// checkParams compares the request parameters with the expected ones, as decoded JSON
+func checkParams(t *testing.T, got, want map[string]interface{}) {
+	t.Helper()
+	encoded, _ := json.Marshal(want)
+	var expected map[string]interface{}
+	json.Unmarshal(encoded, &expected)
+	gotJSON, _ := json.Marshal(got)
+	wantJSON, _ := json.Marshal(expected)
+	if string(gotJSON) != string(wantJSON) {
+		t.Errorf("params = %s, want %s", gotJSON, wantJSON)
+	}
+}
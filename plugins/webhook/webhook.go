@@ -1,14 +1,28 @@
-package main
+package webhook
 
 import (
-	"bytes"
 	"dynamic-notification-system/config"
+	"dynamic-notification-system/plugins"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"net/http"
+	"log/slog"
 )
 
+// configSchema describes the channel settings
+const configSchema = `{
+	"type": "object",
+	"required": ["url"],
+	"properties": {
+		"url": {"type": "string", "minLength": 1, "secret": true, "description": "URL the message is posted to"}
+	}
+}`
+
+func init() {
+	plugins.Register("webhook", New)
+	plugins.RegisterSchema("webhook", configSchema)
+}
+
 // WebhookNotifier struct for generic webhook notifications
 type WebhookNotifier struct {
 	url string
@@ -26,30 +40,39 @@ func (w *WebhookNotifier) Type() string {
 
 // Notify sends a message to a generic webhook
 func (w *WebhookNotifier) Notify(message *config.Message) error {
+	return w.NotifyRecipients(message, nil)
+}
+
+// NotifyRecipients sends a message to a generic webhook, listing the recipients in the
+// payload so the receiving end can route it
+func (w *WebhookNotifier) NotifyRecipients(message *config.Message, recipients []string) error {
 	if w.url == "" {
 		return errors.New("webhook URL is not set")
 	}
 
-	payload := map[string]string{
+	payload := map[string]interface{}{
 		"message": message.Text,
 	}
+	if len(recipients) > 0 {
+		payload["recipients"] = recipients
+	}
 
 	jsonPayload, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
-	resp, err := http.Post(w.url, "application/json", bytes.NewBuffer(jsonPayload))
+	resp, err := plugins.PostJSON(w.url, jsonPayload)
 	if err != nil {
 		return fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("failed to send notification, received status code: %d", resp.StatusCode)
+		return fmt.Errorf("failed to send notification, %w", config.NewStatusError(resp))
 	}
 
-	fmt.Println("Notification sent to Webhook successfully")
+	slog.Debug("Notification sent", "plugin", "webhook")
 	return nil
 }
 
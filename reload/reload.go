@@ -0,0 +1,198 @@
+package reload
+
+import (
+	"dynamic-notification-system/config"
+	"dynamic-notification-system/delivery"
+	"dynamic-notification-system/logging"
+	"dynamic-notification-system/notifier"
+	"dynamic-notification-system/plugins"
+	"dynamic-notification-system/scheduler"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const defaultInterval = 5 * time.Second
+
+// Changes reports what a reload changed
+type Changes struct {
+	Added   []string `json:"added"`   // Channels enabled by the reload
+	Removed []string `json:"removed"` // Channels disabled or deleted by the reload
+	Changed []string `json:"changed"` // Channels whose settings changed
+	// RestartRequired lists the changed sections that only take effect after a restart
+	RestartRequired []string `json:"restart_required"`
+}
+
+// mu serializes reloads so two of them can't interleave their swaps
+var mu sync.Mutex
+var path string
+var current *config.Config
+var notifiers []config.Notifier
+
+// Initialize records the configuration and notifiers the application started with.
+func Initialize(configPath string, cfg *config.Config, loadedNotifiers []config.Notifier) {
+	mu.Lock()
+	defer mu.Unlock()
+	path = configPath
+	current = cfg
+	notifiers = loadedNotifiers
+}
+
+// Notifiers returns the notifiers in use
+func Notifiers() []config.Notifier {
+	mu.Lock()
+	defer mu.Unlock()
+	return notifiers
+}
+
+// Reload reads the configuration file again and swaps the new notifiers into the notifier,
+// scheduler and delivery packages. When the file or a channel is invalid, the current
+// notifiers are kept and an error is returned.
+func Reload() (*Changes, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		return nil, fmt.Errorf("loading %s: %w", path, err)
+	}
+	loaded, err := plugins.LoadPlugins(cfg.Plugins, cfg.Channels)
+	if err != nil {
+		return nil, err
+	}
+	if err := logging.Initialize(cfg.Log); err != nil {
+		plugins.Close(loaded)
+		return nil, err
+	}
+
+	notifier.SetNotifiers(loaded)
+	scheduler.SetNotifiers(loaded)
+	delivery.SetNotifiers(loaded)
+
+	changes := diff(current, cfg)
+	old := notifiers
+	current = cfg
+	notifiers = loaded
+
+	// Calls to external plugins of the old set finish first. Deliveries that still pick it
+	// up get ErrPluginClosed and are retried on the new set.
+	plugins.Close(old)
+
+	slog.Info("Reloaded configuration", "path", path, "added", changes.Added, "removed", changes.Removed, "changed", changes.Changed)
+	if len(changes.RestartRequired) > 0 {
+		slog.Warn("Some changes take effect after a restart", "sections", changes.RestartRequired)
+	}
+	return changes, nil
+}
+
+// diff compares the enabled channels and the settings that can't be reloaded.
+func diff(old, next *config.Config) *Changes {
+	changes := &Changes{Added: []string{}, Removed: []string{}, Changed: []string{}, RestartRequired: []string{}}
+
+	for name, channel := range next.Channels {
+		if !channel.Enabled {
+			continue
+		}
+		previous, ok := old.Channels[name]
+		switch {
+		case !ok || !previous.Enabled:
+			changes.Added = append(changes.Added, name)
+		case !reflect.DeepEqual(previous, channel):
+			changes.Changed = append(changes.Changed, name)
+		}
+	}
+	for name, channel := range old.Channels {
+		if channel.Enabled && !next.Channels[name].Enabled {
+			changes.Removed = append(changes.Removed, name)
+		}
+	}
+
+	restart := map[string]bool{
+		"database":    !reflect.DeepEqual(old.Database, next.Database),
+		"delivery":    !reflect.DeepEqual(old.Delivery, next.Delivery),
+		"scheduler":   old.Scheduler != next.Scheduler,
+		"instance_id": old.InstanceID != next.InstanceID,
+		"reload":      !reflect.DeepEqual(old.Reload, next.Reload),
+		// The plugins are reloaded, only the health check keeps its startup interval
+		"plugins.health_interval": old.Plugins.HealthInterval != next.Plugins.HealthInterval,
+	}
+	for section, changed := range restart {
+		if changed {
+			changes.RestartRequired = append(changes.RestartRequired, section)
+		}
+	}
+
+	for _, names := range [][]string{changes.Added, changes.Removed, changes.Changed, changes.RestartRequired} {
+		sort.Strings(names)
+	}
+	return changes
+}
+
+// Watch reloads the configuration on SIGHUP and, when reload.watch is set, whenever the
+// file changes. It runs until the process exits.
+func Watch() {
+	mu.Lock()
+	cfg := current.Reload
+	mu.Unlock()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var tick <-chan time.Time
+	if cfg.Watch {
+		interval := cfg.Interval
+		if interval <= 0 {
+			interval = defaultInterval
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	last := fileVersion()
+	for {
+		select {
+		case <-hup:
+			slog.Info("Received SIGHUP, reloading configuration")
+		case <-tick:
+			version := fileVersion()
+			if version == last {
+				continue
+			}
+			slog.Info("Configuration file changed, reloading it", "path", path)
+		}
+		last = fileVersion()
+		if _, err := Reload(); err != nil {
+			slog.Error("Error reloading configuration, keeping the current one", "error", err)
+		}
+	}
+}
+
+// fileVersion identifies the content of the configuration file without reading it.
+func fileVersion() string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size())
+}
+
+// HandleReload reloads the configuration and reports what changed
+func HandleReload(w http.ResponseWriter, r *http.Request) {
+	changes, err := Reload()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reloading configuration, keeping the current one: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(changes)
+}
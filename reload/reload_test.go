@@ -0,0 +1,197 @@
+package reload
+
+import (
+	"dynamic-notification-system/config"
+	"dynamic-notification-system/plugins"
+	_ "dynamic-notification-system/plugins/webhook" // Channels of the test configurations
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	webhook := func(enabled bool, url string) config.ChannelConfig {
+		return config.ChannelConfig{Enabled: enabled, Type: "webhook", Settings: map[string]interface{}{"url": url}}
+	}
+	old := &config.Config{
+		Database: config.DatabaseConfig{Driver: "mysql", Port: 3306},
+		Channels: map[string]config.ChannelConfig{
+			"kept":     webhook(true, "https://example.com/kept"),
+			"changed":  webhook(true, "https://example.com/v1"),
+			"disabled": webhook(true, "https://example.com/disabled"),
+			"deleted":  webhook(true, "https://example.com/deleted"),
+			"enabled":  webhook(false, "https://example.com/enabled"),
+			"off":      webhook(false, "https://example.com/off"),
+		},
+		Plugins: config.PluginsConfig{HealthInterval: 30 * time.Second},
+	}
+
+	tests := []struct {
+		name   string
+		change func(next *config.Config)
+		want   Changes
+	}{
+		{"nothing", func(next *config.Config) {}, Changes{}},
+		{
+			"channels",
+			func(next *config.Config) {
+				next.Channels["changed"] = webhook(true, "https://example.com/v2")
+				next.Channels["disabled"] = webhook(false, "https://example.com/disabled")
+				delete(next.Channels, "deleted")
+				next.Channels["enabled"] = webhook(true, "https://example.com/enabled")
+				next.Channels["new"] = webhook(true, "https://example.com/new")
+				// Settings of a channel that stays disabled don't matter
+				next.Channels["off"] = webhook(false, "https://example.com/still-off")
+			},
+			Changes{Added: []string{"enabled", "new"}, Removed: []string{"deleted", "disabled"}, Changed: []string{"changed"}},
+		},
+		{
+			"retry policy",
+			func(next *config.Config) {
+				kept := next.Channels["kept"]
+				kept.Retry.MaxAttempts = 3
+				next.Channels["kept"] = kept
+			},
+			Changes{Changed: []string{"kept"}},
+		},
+		{
+			"restart required",
+			func(next *config.Config) {
+				next.Database.Port = 3307
+				next.Scheduler = true
+				next.Delivery.Workers = 8
+				next.Plugins.HealthInterval = time.Minute
+			},
+			Changes{RestartRequired: []string{"database", "delivery", "plugins.health_interval", "scheduler"}},
+		},
+		{
+			"reloadable sections",
+			func(next *config.Config) {
+				next.Log.Level = "debug"
+				next.Plugins.Dir = "/opt/plugins"
+			},
+			Changes{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := *old
+			next.Channels = map[string]config.ChannelConfig{}
+			for name, channel := range old.Channels {
+				next.Channels[name] = channel
+			}
+			tt.change(&next)
+
+			got := diff(old, &next)
+			for _, list := range []*[]string{&tt.want.Added, &tt.want.Removed, &tt.want.Changed, &tt.want.RestartRequired} {
+				if *list == nil {
+					*list = []string{}
+				}
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("diff = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+// writeConfig replaces the configuration file
+func writeConfig(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func channelNames(notifiers []config.Notifier) string {
+	var names []string
+	for _, n := range notifiers {
+		names = append(names, n.(*plugins.Channel).ChannelName)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, `
+database:
+  driver: memory
+channels:
+  alerts:
+    enabled: true
+    type: webhook
+    url: https://example.com/alerts
+  audit:
+    enabled: true
+    type: webhook
+    url: https://example.com/audit
+`)
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	loaded, err := plugins.LoadPlugins(cfg.Plugins, cfg.Channels)
+	if err != nil {
+		t.Fatalf("LoadPlugins: %v", err)
+	}
+	Initialize(path, cfg, loaded)
+
+	// A file that doesn't parse, or a channel that doesn't load, keeps the current notifiers
+	for _, invalid := range []string{
+		"channels: [",
+		`
+database:
+  driver: memory
+channels:
+  alerts:
+    enabled: true
+    type: webhook
+`,
+	} {
+		writeConfig(t, path, invalid)
+		if _, err := Reload(); err == nil {
+			t.Errorf("Reload of an invalid config succeeded:\n%s", invalid)
+		}
+		if got := Notifiers(); len(got) != len(loaded) || got[0] != loaded[0] {
+			t.Fatalf("notifiers after a failed reload = %s, want the ones loaded at startup", channelNames(got))
+		}
+	}
+
+	writeConfig(t, path, `
+database:
+  driver: sqlite
+channels:
+  alerts:
+    enabled: true
+    type: webhook
+    url: https://example.com/alerts-v2
+  incidents:
+    enabled: true
+    type: webhook
+    url: https://example.com/incidents
+`)
+	changes, err := Reload()
+	if err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	want := Changes{Added: []string{"incidents"}, Removed: []string{"audit"}, Changed: []string{"alerts"}, RestartRequired: []string{"database"}}
+	if !reflect.DeepEqual(*changes, want) {
+		t.Errorf("changes = %+v, want %+v", *changes, want)
+	}
+	if got := channelNames(Notifiers()); got != "alerts,incidents" {
+		t.Errorf("notifiers after reload = %s, want alerts,incidents", got)
+	}
+
+	// The next reload compares against the configuration now in use
+	if changes, err = Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if len(changes.Added)+len(changes.Removed)+len(changes.Changed)+len(changes.RestartRequired) != 0 {
+		t.Errorf("changes of an unchanged file = %+v", *changes)
+	}
+}
@@ -0,0 +1,140 @@
+package scheduler
+
+import (
+	"dynamic-notification-system/config"
+	"dynamic-notification-system/store"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Every replica runs the cron engine. A run is claimed by queueing its delivery with the
+// job ID and nominal fire time, which the deliveries table keeps unique, so whichever
+// replica fires first sends it and the others skip it. If a replica dies, the others
+// keep firing and nothing needs to fail over.
+//
+// Jobs can be changed through any replica, so each one re-reads a job from the store
+// before firing it, and periodically resyncs its live schedule with the store to pick
+// up the jobs created, rescheduled or deleted elsewhere.
+
+// fireTimeLookback bounds how late a cron entry may run and still be matched to its fire time
+const fireTimeLookback = time.Minute
+
+// nominalFireTime returns the scheduled time of the run firing at now, which is the same
+// on every replica even though each one wakes up slightly later than scheduled.
+func nominalFireTime(schedule cron.Schedule, now time.Time) time.Time {
+	// @every schedules count from when each replica started, so runs are matched by period instead
+	if every, ok := schedule.(cron.ConstantDelaySchedule); ok {
+		return now.Truncate(every.Delay)
+	}
+
+	t := schedule.Next(now.Add(-fireTimeLookback))
+	if t.IsZero() || t.After(now) {
+		return now.Truncate(time.Second)
+	}
+	for next := schedule.Next(t); !next.IsZero() && !next.After(now); next = schedule.Next(t) {
+		t = next
+	}
+	return t
+}
+
+// claimedElsewhere reports whether runJob failed because another replica already queued the run
+func claimedElsewhere(err error) bool {
+	return errors.Is(err, store.ErrDuplicateRun)
+}
+
+func (s *Scheduler) resyncLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.resyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.resync(); err != nil {
+				slog.Error("Error resyncing jobs", "error", err)
+			}
+		}
+	}
+}
+
+// resync brings the live schedule in line with the jobs in the store, rescheduling the
+// jobs whose updated_at changed since they were scheduled.
+func (s *Scheduler) resync() error {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+
+	// Listed under jobsMu so a change made through this instance can't be overwritten
+	// with an older copy
+	jobs, err := s.store.ListJobs()
+	if err != nil {
+		return err
+	}
+	stored := map[int]bool{}
+	for _, job := range jobs {
+		stored[job.ID] = true
+		entry, scheduled := s.entries[job.ID]
+		switch {
+		case !shouldSchedule(job):
+			s.removeCronJob(job.ID)
+		case scheduled && sameTime(entry.updatedAt, job.UpdatedAt):
+		default:
+			if err := s.addCronJob(job); err != nil {
+				slog.Error("Error adding cron job", "job", job.Name, "error", err)
+			}
+		}
+	}
+	for id := range s.entries {
+		if !stored[id] {
+			s.removeCronJob(id)
+		}
+	}
+	return nil
+}
+
+// current re-reads a job that is about to fire from the store. It returns false, and
+// updates the live schedule, when the job was deleted, paused, completed or rescheduled
+// since its cron entry was built.
+func (s *Scheduler) current(job config.ScheduledJob) (*config.ScheduledJob, bool) {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+
+	stored, err := s.store.GetJob(job.ID)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		slog.Info("Job was deleted, not firing it", "job", job.Name)
+		s.removeCronJob(job.ID)
+		return nil, false
+	case err != nil:
+		// Better to send what was scheduled than to miss the run
+		slog.Error("Error reloading job, firing it as scheduled", "job", job.Name, "error", err)
+		return &job, true
+	case !shouldSchedule(*stored):
+		slog.Info("Job was paused or completed, not firing it", "job", job.Name)
+		s.removeCronJob(job.ID)
+		return nil, false
+	case !sameSchedule(job, *stored):
+		slog.Info("Job was rescheduled, not firing it", "job", job.Name)
+		if err := s.addCronJob(*stored); err != nil {
+			slog.Error("Error adding cron job", "job", stored.Name, "error", err)
+		}
+		return nil, false
+	}
+	return stored, true
+}
+
+// sameSchedule reports whether both versions of a job fire at the same times
+func sameSchedule(a, b config.ScheduledJob) bool {
+	return a.ScheduleExpression == b.ScheduleExpression && a.Timezone == b.Timezone && sameTime(a.SendAt, b.SendAt)
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
@@ -2,12 +2,19 @@ package scheduler
 
 import (
 	"dynamic-notification-system/config"
+	"dynamic-notification-system/store"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/alecthomas/jsonschema"
+	"github.com/gorilla/mux"
 	"github.com/robfig/cron/v3"
 )
 
@@ -25,45 +32,148 @@ func GetJobSchema() http.HandlerFunc {
 	}
 }
 
-func loadJobs(c *cron.Cron) {
-	log.Printf("=== Loading jobs from DB ===")
-	dbJobs, err := loadJobsFromDB(db)
+func (s *Scheduler) loadJobs() {
+	slog.Info("Loading jobs from the database")
+	dbJobs, err := s.store.ListJobs()
 	if err != nil {
-		log.Println("Error loading jobs from DB:", err)
+		slog.Error("Error loading jobs from the database", "error", err)
 		return
 	}
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+	now := time.Now()
 	for _, job := range dbJobs {
-		addCronJob(c, job, notifiers)
+		if !job.Enabled {
+			slog.Info("Skipping paused job", "job", job.Name)
+			continue
+		}
+		if job.CompletedAt != nil {
+			continue
+		}
+		if err := s.addCronJob(job); err != nil {
+			slog.Error("Error adding cron job", "job", job.Name, "error", err)
+			continue
+		}
+		s.catchUp(job, now)
+	}
+	slog.Info("Finished loading jobs")
+}
+
+// cronParser accepts standard 5-field expressions, 6-field expressions with a leading
+// seconds field, and descriptors such as @daily or @every 90s.
+var cronParser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// jobSchedule returns when the job fires: its cron expression, or a single point in time for one-shots.
+func jobSchedule(job config.ScheduledJob) (cron.Schedule, error) {
+	if isOneShot(job) {
+		return onceSchedule{at: *job.SendAt}, nil
 	}
-	log.Printf("=== Finished loading jobs ===")
+	expression := job.ScheduleExpression
+	if job.Timezone != "" {
+		if strings.HasPrefix(expression, "CRON_TZ=") || strings.HasPrefix(expression, "TZ=") {
+			return nil, fmt.Errorf("set the time zone either in timezone or in the schedule expression, not both")
+		}
+		expression = "CRON_TZ=" + job.Timezone + " " + expression
+	}
+	return cronParser.Parse(expression)
+}
+
+// shouldSchedule reports whether the job belongs in the live schedule
+func shouldSchedule(job config.ScheduledJob) bool {
+	return job.Enabled && job.CompletedAt == nil
 }
 
-func addCronJob(c *cron.Cron, job config.ScheduledJob, notifiers []config.Notifier) {
+// addCronJob schedules the job, replacing the cron entry it already had if any.
+// Callers must hold jobsMu.
+func (s *Scheduler) addCronJob(job config.ScheduledJob) error {
+	schedule, err := jobSchedule(job)
+	if err != nil {
+		return fmt.Errorf("scheduling job %s: %w", job.Name, err)
+	}
+
 	jobCopy := job
-	_, err := c.AddFunc(job.ScheduleExpression, func() {
-		for _, notifier := range notifiers {
-			if notifier.Type() == jobCopy.NotificationType {
-				fmt.Printf("Running job: %s for %s\n", jobCopy.Name, jobCopy.Recipient)
-				err := notifier.Notify(&jobCopy.Message)
-				if err != nil {
-					log.Printf("Error sending notification via %s: %v", notifier.Name(), err)
-				}
-				_, err = db.Exec("UPDATE scheduled_jobs SET last_run = NOW() WHERE id = ?", jobCopy.ID)
-				if err != nil {
-					log.Printf("Error updating last_run: %v", err)
-				}
-			}
+	run := func() {
+		scheduledFor := nominalFireTime(schedule, time.Now())
+		// Another instance may have changed the job since it was scheduled here
+		current, ok := s.current(jobCopy)
+		if !ok {
+			return
 		}
-	})
+		_, err := s.runJob(*current, &scheduledFor, false)
+		if claimedElsewhere(err) {
+			slog.Debug("Run was claimed by another instance", "job", jobCopy.Name, "scheduled_for", scheduledFor.Format(time.RFC3339))
+		} else if err != nil {
+			slog.Error("Error running job", "job", jobCopy.Name, "error", err)
+		}
+	}
+	if isOneShot(job) {
+		if !job.SendAt.After(time.Now()) {
+			// The time passed, e.g. while the service was down, so fire right away.
+			// fireOneShot takes jobsMu, which the caller holds.
+			slog.Info("One-shot job is overdue, firing now", "job", job.Name, "send_at", job.SendAt.Format(time.RFC3339))
+			s.removeCronJob(job.ID)
+			s.entries[job.ID] = cronEntry{updatedAt: job.UpdatedAt}
+			go s.fireOneShot(jobCopy)
+			return nil
+		}
+		run = func() {
+			s.fireOneShot(jobCopy)
+		}
+	}
+
+	entryID := s.cron.Schedule(schedule, cron.FuncJob(run))
+	if old, ok := s.entries[job.ID]; ok && old.id != 0 {
+		s.cron.Remove(old.id)
+	}
+	s.entries[job.ID] = cronEntry{id: entryID, updatedAt: job.UpdatedAt}
+	slog.Info("Added cron job", "job", job.Name)
+	return nil
+}
+
+// removeCronJob unschedules the job. Callers must hold jobsMu.
+func (s *Scheduler) removeCronJob(id int) {
+	if entry, ok := s.entries[id]; ok {
+		if entry.id != 0 {
+			s.cron.Remove(entry.id)
+		}
+		delete(s.entries, id)
+		slog.Info("Removed cron job", "id", id)
+	}
+}
+
+// runJob queues the job's notification. Sending goes through the delivery queue so
+// failures are retried, and last_run is recorded once the notification is sent.
+// scheduledFor is the nominal fire time of scheduled runs, nil for manual ones; only
+// one instance can queue a given scheduled run. catchUp marks replays of missed runs.
+func (s *Scheduler) runJob(job config.ScheduledJob, scheduledFor *time.Time, catchUp bool) (*config.Delivery, error) {
+	slog.Debug("Running job", "job", job.Name, "recipient", job.Recipient)
+	d := config.Delivery{
+		JobID:            &job.ID,
+		NotificationType: job.NotificationType,
+		Recipient:        job.Recipient,
+		Message:          job.Message,
+		CatchUp:          catchUp,
+		ScheduledFor:     scheduledFor,
+	}
+	if err := s.queue.Enqueue(&d); err != nil {
+		return nil, fmt.Errorf("queueing notification for job %s: %w", job.Name, err)
+	}
+	return &d, nil
+}
+
+func jobID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
 	if err != nil {
-		log.Printf("Error adding cron job: %v", err)
-	} else {
-		log.Printf("Added cron job: %s", job.Name)
+		http.Error(w, "invalid job id", http.StatusBadRequest)
+		return 0, false
 	}
+	return id, true
 }
 
 func HandlePostJob(w http.ResponseWriter, r *http.Request) {
-	var job config.ScheduledJob
+	s := defaultScheduler
+	// Jobs start enabled unless the request says otherwise
+	job := config.ScheduledJob{Enabled: true}
 
 	err := json.NewDecoder(r.Body).Decode(&job)
 	if err != nil {
@@ -71,57 +181,258 @@ func HandlePostJob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := validateJob(&job); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := s.validateJob(&job); err != nil {
+		writeValidationError(w, err)
 		return
 	}
 
-	result, err := db.Exec("INSERT INTO scheduled_jobs (name, notification_type, recipient, message, schedule_expression) VALUES (?, ?, ?, ?, ?)",
-		job.Name, job.NotificationType, job.Recipient, job.Message, job.ScheduleExpression)
-	if err != nil {
+	if err := s.store.CreateJob(&job); err != nil {
 		http.Error(w, fmt.Sprintf("Error inserting job: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	id, _ := result.LastInsertId()
-	job.ID = int(id)
-
-	addCronJob(cronInstance, job, notifiers)
+	if shouldSchedule(job) {
+		s.jobsMu.Lock()
+		err := s.addCronJob(job)
+		s.jobsMu.Unlock()
+		if err != nil {
+			// Don't keep a job that will never run
+			if delErr := s.store.DeleteJob(job.ID); delErr != nil {
+				slog.Error("Error removing unschedulable job", "error", delErr)
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(job)
 }
 
 func HandleGetJobs(w http.ResponseWriter, r *http.Request) {
-	var jobs []config.ScheduledJob
+	s := defaultScheduler
+	jobs, err := s.store.ListJobs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs)
+}
+
+// HandleGetJob returns a single scheduled job
+func HandleGetJob(w http.ResponseWriter, r *http.Request) {
+	s := defaultScheduler
+	id, ok := jobID(w, r)
+	if !ok {
+		return
+	}
+
+	job, err := s.store.GetJob(id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// HandlePutJob replaces a scheduled job
+func HandlePutJob(w http.ResponseWriter, r *http.Request) {
+	defaultScheduler.updateJob(w, r, false)
+}
+
+// HandlePatchJob updates the fields present in the request body
+func HandlePatchJob(w http.ResponseWriter, r *http.Request) {
+	defaultScheduler.updateJob(w, r, true)
+}
+
+// updateJob writes the job to the database and swaps its live cron entry.
+// With merge set, the request body is applied on top of the stored job.
+func (s *Scheduler) updateJob(w http.ResponseWriter, r *http.Request, merge bool) {
+	id, ok := jobID(w, r)
+	if !ok {
+		return
+	}
+
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
 
-	rows, err := db.Query("SELECT id, name, notification_type, recipient, message, schedule_expression FROM scheduled_jobs")
+	existing, err := s.store.GetJob(id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var job config.ScheduledJob
-		err := rows.Scan(&job.ID, &job.Name, &job.NotificationType, &job.Recipient, &job.Message, &job.ScheduleExpression)
-		if err != nil {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Like POST, a replaced job is enabled unless the request says otherwise
+	job := config.ScheduledJob{Enabled: true}
+	if merge {
+		job = *existing
+		// send_after replaces the stored send_at, it's only exclusive with one in the same request
+		var fields map[string]json.RawMessage
+		if json.Unmarshal(body, &fields) == nil {
+			if _, ok := fields["send_after"]; ok {
+				if _, ok := fields["send_at"]; !ok {
+					job.SendAt = nil
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(body, &job); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	job.ID = id
+	job.LastRun = existing.LastRun
+	job.CompletedAt = existing.CompletedAt
+
+	// The schedule is parsed here, before touching the database, so a bad
+	// expression can't leave the row and the live schedule out of sync
+	if err := s.validateJob(&job); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	// A fired one-shot job given a new send time is scheduled again
+	if !sameTime(job.SendAt, existing.SendAt) {
+		job.CompletedAt = nil
+	}
+
+	if err := s.store.UpdateJob(&job); err != nil {
+		http.Error(w, fmt.Sprintf("Error updating job: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if shouldSchedule(job) {
+		if err := s.addCronJob(job); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		jobs = append(jobs, job)
+	} else {
+		s.removeCronJob(id)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(jobs)
+	json.NewEncoder(w).Encode(job)
 }
 
-func validateJob(job *config.ScheduledJob) error {
-	if job.Name == "" {
-		return fmt.Errorf("job name is required")
+// HandleDeleteJob removes a scheduled job and its cron entry
+func HandleDeleteJob(w http.ResponseWriter, r *http.Request) {
+	s := defaultScheduler
+	id, ok := jobID(w, r)
+	if !ok {
+		return
+	}
+
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+
+	err := s.store.DeleteJob(id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
 	}
-	if job.ScheduleExpression == "" {
-		return fmt.Errorf("schedule expression is required")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error deleting job: %v", err), http.StatusInternalServerError)
+		return
 	}
-	return nil
+	s.removeCronJob(id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandlePauseJob stops scheduling a job until it is resumed, across restarts
+func HandlePauseJob(w http.ResponseWriter, r *http.Request) {
+	defaultScheduler.setEnabled(w, r, false)
+}
+
+// HandleResumeJob schedules a paused job again
+func HandleResumeJob(w http.ResponseWriter, r *http.Request) {
+	defaultScheduler.setEnabled(w, r, true)
+}
+
+func (s *Scheduler) setEnabled(w http.ResponseWriter, r *http.Request, enabled bool) {
+	id, ok := jobID(w, r)
+	if !ok {
+		return
+	}
+
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+
+	job, err := s.store.GetJob(id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.store.SetJobEnabled(id, enabled); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Read back with the new updated_at, so the next resync doesn't schedule it again
+	if job, err = s.store.GetJob(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if shouldSchedule(*job) {
+		if err := s.addCronJob(*job); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else {
+		s.removeCronJob(id)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// HandleRunJob fires a job right away, whether or not it is paused
+func HandleRunJob(w http.ResponseWriter, r *http.Request) {
+	s := defaultScheduler
+	id, ok := jobID(w, r)
+	if !ok {
+		return
+	}
+
+	job, err := s.store.GetJob(id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	d, err := s.runJob(*job, nil, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"delivery_id": d.ID,
+		"status":      d.Status,
+	})
 }
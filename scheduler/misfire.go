@@ -0,0 +1,72 @@
+package scheduler
+
+import (
+	"dynamic-notification-system/config"
+	"log/slog"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Misfire policies decide what happens to runs missed while the service was down
+const (
+	MisfireSkip     = "skip"      // Forget missed runs (default)
+	MisfireFireOnce = "fire_once" // Send a single catch-up run
+	MisfireFireAll  = "fire_all"  // Send one catch-up run per missed run, up to misfire_limit
+)
+
+const (
+	defaultMisfireLimit = 10
+	maxMisfireLimit     = 100
+)
+
+// missedRuns returns up to limit fire times of the schedule after since and not after now.
+func missedRuns(schedule cron.Schedule, since, now time.Time, limit int) []time.Time {
+	var missed []time.Time
+	for t := schedule.Next(since); !t.IsZero() && !t.After(now) && len(missed) < limit; t = schedule.Next(t) {
+		missed = append(missed, t)
+	}
+	return missed
+}
+
+// catchUp replays the runs a recurring job missed while the service was down,
+// as its misfire policy says. Each replayed run is marked as a catch-up in the delivery history.
+func (s *Scheduler) catchUp(job config.ScheduledJob, now time.Time) {
+	policy := job.MisfirePolicy
+	if policy == "" || policy == MisfireSkip || isOneShot(job) {
+		return
+	}
+
+	schedule, err := jobSchedule(job)
+	if err != nil {
+		slog.Error("Error checking missed runs", "job", job.Name, "error", err)
+		return
+	}
+	since, err := s.store.LastFiredAt(job.ID)
+	if err != nil {
+		slog.Error("Error checking missed runs", "job", job.Name, "error", err)
+		return
+	}
+
+	limit := 1
+	if policy == MisfireFireAll {
+		limit = job.MisfireLimit
+		if limit <= 0 {
+			limit = defaultMisfireLimit
+		}
+	}
+	missed := missedRuns(schedule, since, now, limit)
+	if len(missed) == 0 {
+		return
+	}
+
+	slog.Info("Job missed runs, sending catch-up runs", "job", job.Name, "since", since.Format(time.RFC3339), "runs", len(missed))
+	for _, t := range missed {
+		scheduledFor := t
+		// Replicas starting together find the same missed runs, but only one queues each
+		_, err := s.runJob(job, &scheduledFor, true)
+		if err != nil && !claimedElsewhere(err) {
+			slog.Error("Error running catch-up", "job", job.Name, "error", err)
+		}
+	}
+}
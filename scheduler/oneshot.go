@@ -0,0 +1,46 @@
+package scheduler
+
+import (
+	"dynamic-notification-system/config"
+	"log/slog"
+	"time"
+)
+
+// onceSchedule is a cron.Schedule that fires a single time
+type onceSchedule struct {
+	at time.Time
+}
+
+// Next returns the fire time until it has passed, then the zero time, which cron never runs.
+func (s onceSchedule) Next(t time.Time) time.Time {
+	if s.at.After(t) {
+		return s.at
+	}
+	return time.Time{}
+}
+
+// isOneShot reports whether the job fires once at SendAt instead of on a cron expression
+func isOneShot(job config.ScheduledJob) bool {
+	return job.SendAt != nil
+}
+
+// fireOneShot runs a one-shot job and marks it completed so it never fires again.
+func (s *Scheduler) fireOneShot(job config.ScheduledJob) {
+	current, ok := s.current(job)
+	if !ok {
+		return
+	}
+	job = *current
+	// If another instance already queued the run, the job is still done
+	if _, err := s.runJob(job, job.SendAt, false); err != nil && !claimedElsewhere(err) {
+		slog.Error("Error running job", "job", job.Name, "error", err)
+		return
+	}
+
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+	if err := s.store.CompleteJob(job.ID); err != nil {
+		slog.Error("Error completing job", "job", job.Name, "error", err)
+	}
+	s.removeCronJob(job.ID)
+}
@@ -2,47 +2,109 @@ package scheduler
 
 import (
 	"dynamic-notification-system/config"
-	"fmt"
+	"dynamic-notification-system/delivery"
+	"dynamic-notification-system/store"
+	"sync"
+	"time"
 
-	"database/sql"
-
-	_ "github.com/go-sql-driver/mysql"
 	"github.com/robfig/cron/v3"
 )
 
-var cronInstance *cron.Cron
-var db *sql.DB
-var notifiers []config.Notifier
-
-// Initialize sets up the database and cron instance.
-func Initialize(cfg *config.Config, loadedNotifiers []config.Notifier) error {
-	var err error
-	notifiers = loadedNotifiers
-
-	// Construct DB connection string
-	dbConnStr := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s",
-		cfg.Database.User,
-		cfg.Database.Password,
-		cfg.Database.Host,
-		cfg.Database.Port,
-		cfg.Database.Name,
-	)
-
-	db, err = sql.Open("mysql", dbConnStr)
-	if err != nil {
-		return fmt.Errorf("failed to connect to DB: %w", err)
+// Scheduler fires the jobs of a store on their schedule and queues their deliveries.
+// Schedulers of several instances can share the store, each run is queued by a single one.
+type Scheduler struct {
+	cron  *cron.Cron
+	store store.JobStore
+	queue *delivery.Queue
+
+	notifiersMu sync.RWMutex
+	notifiers   []config.Notifier
+
+	// jobsMu serializes job changes so the database and the live schedule stay in step
+	jobsMu  sync.Mutex
+	entries map[int]cronEntry
+
+	// resyncInterval is how often the live schedule is compared with the store, for jobs
+	// changed through other instances
+	resyncInterval time.Duration
+	stop           chan struct{}
+	wg             sync.WaitGroup
+}
+
+// cronEntry is the live schedule of a job and the version of the job it was built from
+type cronEntry struct {
+	id        cron.EntryID // 0 while an overdue one-shot job fires
+	updatedAt *time.Time
+}
+
+const defaultResyncInterval = 10 * time.Second
+
+// defaultScheduler is the scheduler started by Initialize, which the handlers use
+var defaultScheduler *Scheduler
+
+// New returns a scheduler of the jobs in the store, queueing their runs on the queue.
+// Nothing fires until Start is called.
+func New(st store.JobStore, queue *delivery.Queue, notifiers []config.Notifier) *Scheduler {
+	return &Scheduler{
+		// Jobs without a timezone run in the server's local time zone
+		cron:           cron.New(cron.WithParser(cronParser)),
+		store:          st,
+		queue:          queue,
+		notifiers:      notifiers,
+		entries:        map[int]cronEntry{},
+		resyncInterval: defaultResyncInterval,
 	}
+}
 
-	cronInstance = cron.New()
-	loadJobs(cronInstance)
-	go cronInstance.Start()
+// Initialize sets up the default scheduler on top of the job store and the default delivery queue.
+func Initialize(cfg *config.Config, st store.JobStore, loadedNotifiers []config.Notifier) error {
+	defaultScheduler = New(st, delivery.Default(), loadedNotifiers)
+	defaultScheduler.Start()
 	return nil
 }
 
-// Shutdown gracefully stops the cron instance and closes the database.
+// Start loads the jobs, catches up on the runs they missed and starts the cron engine.
+func (s *Scheduler) Start() {
+	s.loadJobs()
+	s.cron.Start()
+	s.stop = make(chan struct{})
+	s.wg.Add(1)
+	go s.resyncLoop()
+}
+
+// SetNotifiers replaces the notifiers the default scheduler validates new and updated jobs against
+func SetNotifiers(n []config.Notifier) {
+	if defaultScheduler != nil {
+		defaultScheduler.SetNotifiers(n)
+	}
+}
+
+// SetNotifiers replaces the notifiers new and updated jobs are validated against
+func (s *Scheduler) SetNotifiers(n []config.Notifier) {
+	s.notifiersMu.Lock()
+	defer s.notifiersMu.Unlock()
+	s.notifiers = n
+}
+
+func (s *Scheduler) currentNotifiers() []config.Notifier {
+	s.notifiersMu.RLock()
+	defer s.notifiersMu.RUnlock()
+	return s.notifiers
+}
+
+// Shutdown gracefully stops the default scheduler.
 func Shutdown() {
-	cronInstance.Stop()
-	if db != nil {
-		db.Close()
+	if defaultScheduler != nil {
+		defaultScheduler.Stop()
+	}
+}
+
+// Stop stops the cron engine and waits for running jobs to finish.
+func (s *Scheduler) Stop() {
+	if s.stop != nil {
+		close(s.stop)
+		s.wg.Wait()
+		s.stop = nil
 	}
+	<-s.cron.Stop().Done()
 }
@@ -0,0 +1,333 @@
+package scheduler
+
+import (
+	"dynamic-notification-system/config"
+	"dynamic-notification-system/delivery"
+	"dynamic-notification-system/store"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// countingNotifier records the messages it was asked to send, by title
+type countingNotifier struct {
+	mu   sync.Mutex
+	sent map[string]int
+}
+
+func (n *countingNotifier) Name() string { return "Counting" }
+func (n *countingNotifier) Type() string { return "counting" }
+
+func (n *countingNotifier) Notify(message *config.Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sent[message.Title]++
+	return nil
+}
+
+func (n *countingNotifier) count(title string) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.sent[title]
+}
+
+// sharedStores returns two handles on the same store, as two instances would have
+func sharedStores(t *testing.T, driver string) (store.Store, store.Store) {
+	if driver == "memory" {
+		st := store.NewMemoryStore()
+		return st, st
+	}
+	path := filepath.Join(t.TempDir(), "notifications.db")
+	var stores [2]store.Store
+	for i := range stores {
+		st, err := store.Open(config.DatabaseConfig{Driver: driver, Path: path})
+		if err != nil {
+			t.Fatalf("opening store: %v", err)
+		}
+		t.Cleanup(func() { st.Close() })
+		if err := store.Migrate(st); err != nil {
+			t.Fatalf("migrating store: %v", err)
+		}
+		stores[i] = st
+	}
+	return stores[0], stores[1]
+}
+
+// TestSharedStore runs two instances on one store and checks that every run is queued,
+// and sent, by only one of them
+func TestSharedStore(t *testing.T) {
+	for _, driver := range []string{"memory", "sqlite"} {
+		t.Run(driver, func(t *testing.T) {
+			first, second := sharedStores(t, driver)
+			notifier := &countingNotifier{sent: map[string]int{}}
+			notifiers := []config.Notifier{notifier}
+
+			// A one-shot job that came due while both instances were down, and a recurring one
+			sendAt := time.Now().Add(-time.Minute).Truncate(time.Second)
+			oneShot := &config.ScheduledJob{Name: "one-shot", NotificationType: "counting", Message: config.Message{Title: "one-shot"}, SendAt: &sendAt, Enabled: true}
+			recurring := &config.ScheduledJob{Name: "recurring", NotificationType: "counting", Message: config.Message{Title: "recurring"}, ScheduleExpression: "@every 1s", Enabled: true}
+			for _, job := range []*config.ScheduledJob{oneShot, recurring} {
+				if err := first.CreateJob(job); err != nil {
+					t.Fatalf("CreateJob: %v", err)
+				}
+			}
+
+			var schedulers []*Scheduler
+			for i, st := range []store.Store{first, second} {
+				queue := delivery.NewQueue([]string{"a", "b"}[i], st, notifiers)
+				if err := queue.Start(config.DeliveryConfig{Workers: 2, PollInterval: 10 * time.Millisecond}); err != nil {
+					t.Fatalf("starting queue: %v", err)
+				}
+				t.Cleanup(queue.Shutdown)
+				s := New(st, queue, notifiers)
+				schedulers = append(schedulers, s)
+			}
+			var wg sync.WaitGroup
+			for _, s := range schedulers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					s.Start()
+				}()
+			}
+			wg.Wait()
+
+			// Both instances also run the job by hand for the same scheduled time, as cron would
+			scheduledFor := time.Date(2030, 1, 1, 9, 0, 0, 0, time.UTC)
+			manual := *recurring
+			manual.Message = config.Message{Title: "manual"}
+			results := make(chan error, 10)
+			for i := 0; i < cap(results); i++ {
+				go func() {
+					_, err := schedulers[i%2].runJob(manual, &scheduledFor, false)
+					results <- err
+				}()
+			}
+			queued := 0
+			for i := 0; i < cap(results); i++ {
+				err := <-results
+				if err == nil {
+					queued++
+				} else if !claimedElsewhere(err) {
+					t.Errorf("runJob: %v", err)
+				}
+			}
+			if queued != 1 {
+				t.Errorf("run queued %d times, want once", queued)
+			}
+
+			time.Sleep(2500 * time.Millisecond)
+			for _, s := range schedulers {
+				s.Stop()
+			}
+			// Let the workers send what the last cron runs queued
+			time.Sleep(200 * time.Millisecond)
+
+			if n := notifier.count("one-shot"); n != 1 {
+				t.Errorf("one-shot job sent %d times, want once", n)
+			}
+			if n := notifier.count("manual"); n != 1 {
+				t.Errorf("manual run sent %d times, want once", n)
+			}
+
+			deliveries, err := first.ListDeliveries(store.DeliveryFilter{JobID: &recurring.ID, Limit: 100})
+			if err != nil {
+				t.Fatalf("ListDeliveries: %v", err)
+			}
+			runs := map[time.Time]int{}
+			for _, d := range deliveries {
+				if d.Message.Title != "recurring" {
+					continue
+				}
+				if d.Status != store.StatusSent {
+					t.Errorf("delivery %d is %s, want sent", d.ID, d.Status)
+				}
+				runs[d.ScheduledFor.UTC()]++
+			}
+			if len(runs) < 2 {
+				t.Errorf("recurring job ran %d times in 2.5s, want at least 2", len(runs))
+			}
+			for run, n := range runs {
+				if n != 1 {
+					t.Errorf("run %s queued %d times, want once", run.Format(time.RFC3339), n)
+				}
+			}
+			if n := notifier.count("recurring"); n != len(runs) {
+				t.Errorf("recurring job sent %d times for %d runs", n, len(runs))
+			}
+
+			job, err := second.GetJob(oneShot.ID)
+			if err != nil {
+				t.Fatalf("GetJob: %v", err)
+			}
+			if job.CompletedAt == nil {
+				t.Errorf("one-shot job not completed")
+			}
+		})
+	}
+}
+
+// serve sends an API request to the instance and fails the test if it's rejected
+func serve(t *testing.T, s *Scheduler, method, path, body string) {
+	t.Helper()
+	if w := request(s, method, path, body); w.Code >= http.StatusBadRequest {
+		t.Fatalf("%s %s: %d %s", method, path, w.Code, w.Body)
+	}
+}
+
+// request sends an API request to the instance
+func request(s *Scheduler, method, path, body string) *httptest.ResponseRecorder {
+	r := mux.NewRouter()
+	r.HandleFunc("/jobs", HandlePostJob).Methods("POST")
+	r.HandleFunc("/jobs/{id:[0-9]+}", HandlePatchJob).Methods("PATCH")
+	r.HandleFunc("/jobs/{id:[0-9]+}", HandleDeleteJob).Methods("DELETE")
+	r.HandleFunc("/jobs/{id:[0-9]+}/pause", HandlePauseJob).Methods("POST")
+
+	previous := defaultScheduler
+	defaultScheduler = s
+	defer func() { defaultScheduler = previous }()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return w
+}
+
+// TestJobChangesReachOtherInstances changes jobs through one instance and checks that the
+// other one picks up new jobs and only fires the current version of the others
+func TestJobChangesReachOtherInstances(t *testing.T) {
+	for _, driver := range []string{"memory", "sqlite"} {
+		t.Run(driver, func(t *testing.T) {
+			first, second := sharedStores(t, driver)
+			notifier := &countingNotifier{sent: map[string]int{}}
+			notifiers := []config.Notifier{notifier}
+
+			var instances []*Scheduler
+			for i, st := range []store.Store{first, second} {
+				queue := delivery.NewQueue([]string{"a", "b"}[i], st, notifiers)
+				if err := queue.Start(config.DeliveryConfig{Workers: 2, PollInterval: 10 * time.Millisecond}); err != nil {
+					t.Fatalf("starting queue: %v", err)
+				}
+				t.Cleanup(queue.Shutdown)
+				s := New(st, queue, notifiers)
+				// Resynced by hand, so that b can only rely on checking jobs as they fire
+				s.resyncInterval = time.Hour
+				s.Start()
+				t.Cleanup(s.Stop)
+				instances = append(instances, s)
+			}
+			a, b := instances[0], instances[1]
+
+			// Created through a after b started
+			names := []string{"deleted", "paused", "updated", "rescheduled", "failover"}
+			for _, name := range names {
+				serve(t, a, "POST", "/jobs", fmt.Sprintf(`{"name": %q, "notification_type": "counting", "message": {"title": %q}, "schedule_expression": "@every 1s"}`, name, name))
+			}
+			if err := b.resync(); err != nil {
+				t.Fatalf("resync: %v", err)
+			}
+			b.jobsMu.Lock()
+			scheduled := len(b.entries)
+			b.jobsMu.Unlock()
+			if scheduled != len(names) {
+				t.Fatalf("b scheduled %d jobs, want %d", scheduled, len(names))
+			}
+
+			serve(t, a, "DELETE", "/jobs/1", "")
+			serve(t, a, "POST", "/jobs/2/pause", "")
+			serve(t, a, "PATCH", "/jobs/3", `{"message": {"title": "updated v2"}}`)
+			serve(t, a, "PATCH", "/jobs/4", `{"schedule_expression": "@every 1h"}`)
+			// a dies, b takes over its jobs
+			a.Stop()
+			// Let the workers send what was queued before the changes
+			time.Sleep(200 * time.Millisecond)
+			before := map[string]int{}
+			for _, name := range names {
+				before[name] = notifier.count(name)
+			}
+
+			time.Sleep(2500 * time.Millisecond)
+			for _, name := range []string{"deleted", "paused", "updated", "rescheduled"} {
+				if n := notifier.count(name) - before[name]; n != 0 {
+					t.Errorf("%s job sent %d times after the change", name, n)
+				}
+			}
+			if n := notifier.count("updated v2"); n < 2 {
+				t.Errorf("updated job sent %d times with its new message, want at least 2", n)
+			}
+			if n := notifier.count("failover") - before["failover"]; n < 2 {
+				t.Errorf("failover job sent %d times by b, want at least 2", n)
+			}
+
+			// Resyncing drops what b still had scheduled
+			if err := b.resync(); err != nil {
+				t.Fatalf("resync: %v", err)
+			}
+			b.jobsMu.Lock()
+			defer b.jobsMu.Unlock()
+			for id := range b.entries {
+				if id == 1 || id == 2 {
+					t.Errorf("job %d is still scheduled on b", id)
+				}
+			}
+		})
+	}
+}
+
+// TestRescheduleOneShot gives a one-shot job a new send time, before and after it fired
+func TestRescheduleOneShot(t *testing.T) {
+	for _, driver := range []string{"memory", "sqlite"} {
+		t.Run(driver, func(t *testing.T) {
+			st, _ := sharedStores(t, driver)
+			notifier := &countingNotifier{sent: map[string]int{}}
+			notifiers := []config.Notifier{notifier}
+			queue := delivery.NewQueue("a", st, notifiers)
+			if err := queue.Start(config.DeliveryConfig{Workers: 1, PollInterval: 10 * time.Millisecond}); err != nil {
+				t.Fatalf("starting queue: %v", err)
+			}
+			t.Cleanup(queue.Shutdown)
+			s := New(st, queue, notifiers)
+			s.Start()
+			t.Cleanup(s.Stop)
+
+			serve(t, s, "POST", "/jobs", `{"name": "reminder", "notification_type": "counting", "message": {"title": "reminder"}, "send_at": "2099-01-01T00:00:00Z"}`)
+			// send_after replaces the stored send_at
+			serve(t, s, "PATCH", "/jobs/1", `{"send_after": "500ms"}`)
+			// but not one given with it
+			if w := request(s, "PATCH", "/jobs/1", `{"send_at": "2099-01-01T00:00:00Z", "send_after": "1h"}`); w.Code != http.StatusBadRequest {
+				t.Errorf("PATCH with send_at and send_after: %d %s, want 400", w.Code, w.Body)
+			}
+			time.Sleep(1500 * time.Millisecond)
+			if n := notifier.count("reminder"); n != 1 {
+				t.Fatalf("one-shot job sent %d times, want once", n)
+			}
+			job, err := st.GetJob(1)
+			if err != nil {
+				t.Fatalf("GetJob: %v", err)
+			}
+			if job.CompletedAt == nil {
+				t.Fatalf("one-shot job not completed after firing")
+			}
+
+			// Other changes leave a fired job completed
+			serve(t, s, "PATCH", "/jobs/1", `{"message": {"title": "reminder"}}`)
+			if job, err = st.GetJob(1); err != nil || job.CompletedAt == nil {
+				t.Fatalf("GetJob = %+v, %v, want the job still completed", job, err)
+			}
+			// A new send time fires it again
+			serve(t, s, "PATCH", "/jobs/1", `{"send_after": "500ms"}`)
+			time.Sleep(1500 * time.Millisecond)
+			if n := notifier.count("reminder"); n != 2 {
+				t.Errorf("rescheduled job sent %d times in all, want twice", n)
+			}
+			if job, err = st.GetJob(1); err != nil || job.CompletedAt == nil {
+				t.Errorf("GetJob = %+v, %v, want the job completed again", job, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package scheduler
+
+import (
+	"dynamic-notification-system/config"
+	"dynamic-notification-system/plugins"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// validationErrors maps a job field to what is wrong with it
+type validationErrors map[string]string
+
+func (v validationErrors) Error() string {
+	fields := make([]string, 0, len(v))
+	for field := range v {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	messages := make([]string, 0, len(v))
+	for _, field := range fields {
+		messages = append(messages, fmt.Sprintf("%s: %s", field, v[field]))
+	}
+	return strings.Join(messages, "; ")
+}
+
+// writeValidationError responds with 400 and the per-field errors as JSON
+func writeValidationError(w http.ResponseWriter, err error) {
+	fields, ok := err.(validationErrors)
+	if !ok {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":  "invalid job",
+		"fields": fields,
+	})
+}
+
+// validateJob checks every field of the job and reports all problems at once.
+// It also resolves send_after into send_at.
+func (s *Scheduler) validateJob(job *config.ScheduledJob) error {
+	errs := validationErrors{}
+
+	if job.Name == "" {
+		errs["name"] = "job name is required"
+	}
+
+	if job.NotificationType == "" {
+		errs["notification_type"] = "notification type is required"
+	} else if matching := plugins.Select(s.currentNotifiers(), job.NotificationType); len(matching) == 0 {
+		errs["notification_type"] = fmt.Sprintf("no channel or notifier type %q loaded", job.NotificationType)
+	} else {
+		for _, notifier := range matching {
+			if err := validateMessage(notifier, &job.Message); err != nil {
+				errs["message"] = err.Error()
+				break
+			}
+		}
+		for _, notifier := range matching {
+			if err := validateRecipients(notifier, job.Recipient); err != nil {
+				errs["recipient"] = err.Error()
+				break
+			}
+		}
+	}
+
+	// send_after is shorthand for a send_at relative to now
+	if job.SendAfter != "" {
+		delay, err := time.ParseDuration(job.SendAfter)
+		switch {
+		case job.SendAt != nil:
+			errs["send_after"] = "send_at and send_after are mutually exclusive"
+		case err != nil || delay <= 0:
+			errs["send_after"] = "send_after must be a positive duration such as \"90m\""
+		default:
+			sendAt := time.Now().Add(delay)
+			job.SendAt = &sendAt
+			job.SendAfter = ""
+		}
+	}
+
+	// Whole seconds, so every instance sees the same fire time once it is stored
+	if job.SendAt != nil {
+		sendAt := job.SendAt.Truncate(time.Second)
+		job.SendAt = &sendAt
+	}
+
+	if job.Timezone != "" {
+		if _, err := time.LoadLocation(job.Timezone); err != nil {
+			errs["timezone"] = fmt.Sprintf("unknown timezone %q", job.Timezone)
+		}
+	}
+
+	switch job.MisfirePolicy {
+	case "", MisfireSkip, MisfireFireOnce:
+		if job.MisfireLimit != 0 {
+			errs["misfire_limit"] = "misfire_limit only applies to the fire_all policy"
+		}
+	case MisfireFireAll:
+		if job.MisfireLimit < 0 || job.MisfireLimit > maxMisfireLimit {
+			errs["misfire_limit"] = fmt.Sprintf("misfire_limit must be between 1 and %d, or 0 for the default of %d", maxMisfireLimit, defaultMisfireLimit)
+		}
+	default:
+		errs["misfire_policy"] = fmt.Sprintf("unknown misfire policy %q, expected %s, %s or %s", job.MisfirePolicy, MisfireSkip, MisfireFireOnce, MisfireFireAll)
+	}
+
+	switch {
+	case job.ScheduleExpression == "" && job.SendAt == nil && job.SendAfter == "":
+		errs["schedule_expression"] = "schedule expression, send_at or send_after is required"
+	case job.ScheduleExpression != "" && (job.SendAt != nil || job.SendAfter != ""):
+		errs["schedule_expression"] = "a job has either a schedule expression or a send time, not both"
+	case job.ScheduleExpression != "" && errs["timezone"] == "":
+		if _, err := jobSchedule(*job); err != nil {
+			errs["schedule_expression"] = fmt.Sprintf("invalid schedule expression %q: %v", job.ScheduleExpression, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateMessage checks the message against the channel's own requirements, when it has any.
+func validateMessage(notifier config.Notifier, message *config.Message) error {
+	if message.Text == "" && message.Title == "" {
+		return fmt.Errorf("message or title is required")
+	}
+	if validator, ok := notifier.(config.MessageValidator); ok {
+		return validator.ValidateMessage(message)
+	}
+	return nil
+}
+
+// validateRecipients checks the recipients against the channel's own requirements, when it has any.
+func validateRecipients(notifier config.Notifier, recipient string) error {
+	recipients := config.Recipients(recipient)
+	if validator, ok := notifier.(config.RecipientValidator); ok && len(recipients) > 0 {
+		return validator.ValidateRecipients(recipients)
+	}
+	return nil
+}
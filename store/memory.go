@@ -0,0 +1,407 @@
+package store
+
+import (
+	"dynamic-notification-system/config"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memoryJob struct {
+	job       config.ScheduledJob
+	createdAt time.Time
+}
+
+type memoryDelivery struct {
+	delivery      config.Delivery
+	nextAttemptAt time.Time
+	claimedBy     string
+	claimedAt     time.Time
+}
+
+type runKey struct {
+	jobID        int
+	scheduledFor time.Time
+}
+
+// MemoryStore keeps everything in process memory. It needs no setup, which makes it handy
+// for development and tests, but nothing survives a restart and it can't be shared by replicas.
+type MemoryStore struct {
+	mu          sync.Mutex
+	jobs        map[int]*memoryJob
+	deliveries  map[int64]*memoryDelivery
+	runs        map[runKey]int64
+	deadLetters map[int64]*config.DeadLetter
+
+	lastJobID        int
+	lastDeliveryID   int64
+	lastDeadLetterID int64
+}
+
+// NewMemoryStore returns an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		jobs:        map[int]*memoryJob{},
+		deliveries:  map[int64]*memoryDelivery{},
+		runs:        map[runKey]int64{},
+		deadLetters: map[int64]*config.DeadLetter{},
+	}
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// Jobs
+
+func (s *MemoryStore) ListJobs() ([]config.ScheduledJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := []config.ScheduledJob{}
+	for _, j := range s.jobs {
+		jobs = append(jobs, j.job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+	return jobs, nil
+}
+
+func (s *MemoryStore) GetJob(id int) (*config.ScheduledJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	job := j.job
+	return &job, nil
+}
+
+func (s *MemoryStore) CreateJob(job *config.ScheduledJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastJobID++
+	job.ID = s.lastJobID
+	updatedAt := time.Now()
+	job.UpdatedAt = &updatedAt
+	s.jobs[job.ID] = &memoryJob{job: *job, createdAt: time.Now()}
+	return nil
+}
+
+func (s *MemoryStore) UpdateJob(job *config.ScheduledJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[job.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	// Runs are recorded by the store
+	updated := *job
+	updated.LastRun = j.job.LastRun
+	updatedAt := time.Now()
+	updated.UpdatedAt = &updatedAt
+	j.job = updated
+	job.UpdatedAt = &updatedAt
+	return nil
+}
+
+func (s *MemoryStore) DeleteJob(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.jobs[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.jobs, id)
+	return nil
+}
+
+func (s *MemoryStore) SetJobEnabled(id int, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+	if !ok {
+		return ErrNotFound
+	}
+	j.job.Enabled = enabled
+	now := time.Now()
+	j.job.UpdatedAt = &now
+	return nil
+}
+
+func (s *MemoryStore) CompleteJob(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if j, ok := s.jobs[id]; ok {
+		now := time.Now()
+		j.job.CompletedAt = &now
+		j.job.UpdatedAt = &now
+	}
+	return nil
+}
+
+func (s *MemoryStore) LastFiredAt(id int) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+	if !ok {
+		return time.Time{}, ErrNotFound
+	}
+	since := j.createdAt
+	if j.job.LastRun.Valid {
+		since = j.job.LastRun.Time
+	}
+	for _, d := range s.deliveries {
+		if d.delivery.JobID != nil && *d.delivery.JobID == id && d.delivery.CreatedAt.After(since) {
+			since = d.delivery.CreatedAt
+		}
+	}
+	return since, nil
+}
+
+// Deliveries
+
+func (s *MemoryStore) InsertDelivery(d *config.Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var key runKey
+	if d.JobID != nil && d.ScheduledFor != nil {
+		key = runKey{jobID: *d.JobID, scheduledFor: d.ScheduledFor.UTC()}
+		if _, ok := s.runs[key]; ok {
+			return ErrDuplicateRun
+		}
+	}
+
+	now := time.Now()
+	s.lastDeliveryID++
+	d.ID = s.lastDeliveryID
+	d.Status = StatusPending
+	d.CreatedAt = now
+	d.UpdatedAt = now
+	s.deliveries[d.ID] = &memoryDelivery{delivery: *d}
+	if d.JobID != nil && d.ScheduledFor != nil {
+		s.runs[key] = d.ID
+	}
+	return nil
+}
+
+func (s *MemoryStore) ClaimDelivery(instanceID string) (*config.Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var next *memoryDelivery
+	for _, d := range s.deliveries {
+		if d.delivery.Status != StatusPending || d.nextAttemptAt.After(now) {
+			continue
+		}
+		if next == nil || d.delivery.ID < next.delivery.ID {
+			next = d
+		}
+	}
+	if next == nil {
+		return nil, nil
+	}
+
+	next.delivery.Status = StatusProcessing
+	next.delivery.UpdatedAt = now
+	next.claimedBy = instanceID
+	next.claimedAt = now
+	d := next.delivery
+	return &d, nil
+}
+
+func (s *MemoryStore) GetDelivery(id int64) (*config.Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.deliveries[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	delivery := d.delivery
+	return &delivery, nil
+}
+
+func (s *MemoryStore) ListDeliveries(filter DeliveryFilter) ([]config.Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deliveries := []config.Delivery{}
+	for _, md := range s.deliveries {
+		d := md.delivery
+		if filter.Status != "" && d.Status != filter.Status {
+			continue
+		}
+		if filter.Channel != "" && d.NotificationType != filter.Channel && d.Channel != filter.Channel {
+			continue
+		}
+		if filter.JobID != nil && (d.JobID == nil || *d.JobID != *filter.JobID) {
+			continue
+		}
+		if filter.From != nil && d.CreatedAt.Before(*filter.From) {
+			continue
+		}
+		if filter.To != nil && !d.CreatedAt.Before(*filter.To) {
+			continue
+		}
+		deliveries = append(deliveries, d)
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID > deliveries[j].ID })
+	if filter.Limit > 0 && len(deliveries) > filter.Limit {
+		deliveries = deliveries[:filter.Limit]
+	}
+	return deliveries, nil
+}
+
+// update copies the outcome of an attempt onto the stored delivery
+func (s *MemoryStore) update(d *config.Delivery, status string, now time.Time) (*memoryDelivery, error) {
+	md, ok := s.deliveries[d.ID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	md.delivery.Status = status
+	md.delivery.Channel = d.Channel
+	md.delivery.Attempts = d.Attempts
+	md.delivery.ResponseCode = d.ResponseCode
+	md.delivery.LastError = d.LastError
+	md.delivery.ProviderMessageID = d.ProviderMessageID
+	md.delivery.Completed = d.Completed
+	md.delivery.LastAttemptAt = &now
+	md.delivery.UpdatedAt = now
+	return md, nil
+}
+
+func (s *MemoryStore) MarkSent(d *config.Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	md, err := s.update(d, StatusSent, now)
+	if err != nil {
+		return err
+	}
+	md.delivery.ResponseCode = nil
+	md.delivery.LastError = d.LastError // Recipients the provider rejected, if any
+	md.delivery.SentAt = &now
+	if d.JobID != nil {
+		if j, ok := s.jobs[*d.JobID]; ok {
+			j.job.LastRun.Time = now
+			j.job.LastRun.Valid = true
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) ScheduleRetry(d *config.Delivery, nextAttempt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	md, err := s.update(d, StatusPending, time.Now())
+	if err != nil {
+		return err
+	}
+	md.nextAttemptAt = nextAttempt
+	return nil
+}
+
+func (s *MemoryStore) MarkDead(d *config.Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if _, err := s.update(d, StatusFailed, now); err != nil {
+		return err
+	}
+	s.lastDeadLetterID++
+	s.deadLetters[s.lastDeadLetterID] = &config.DeadLetter{
+		ID:               s.lastDeadLetterID,
+		DeliveryID:       d.ID,
+		JobID:            d.JobID,
+		NotificationType: d.NotificationType,
+		Recipient:        d.Recipient,
+		Message:          d.Message,
+		Attempts:         d.Attempts,
+		LastError:        d.LastError,
+		Completed:        d.Completed,
+		CreatedAt:        now,
+	}
+	return nil
+}
+
+func (s *MemoryStore) RequeueClaimedBy(instanceID string) (int64, error) {
+	return s.requeue(func(d *memoryDelivery) bool { return d.claimedBy == instanceID })
+}
+
+func (s *MemoryStore) RequeueClaimedBefore(t time.Time) (int64, error) {
+	return s.requeue(func(d *memoryDelivery) bool { return d.claimedAt.Before(t) })
+}
+
+func (s *MemoryStore) requeue(match func(d *memoryDelivery) bool) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	now := time.Now()
+	for _, d := range s.deliveries {
+		if d.delivery.Status == StatusProcessing && match(d) {
+			d.delivery.Status = StatusPending
+			d.delivery.UpdatedAt = now
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Dead letters
+
+func (s *MemoryStore) ListDeadLetters() ([]config.DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deadLetters := []config.DeadLetter{}
+	for _, dl := range s.deadLetters {
+		deadLetters = append(deadLetters, *dl)
+	}
+	sort.Slice(deadLetters, func(i, j int) bool { return deadLetters[i].ID < deadLetters[j].ID })
+	return deadLetters, nil
+}
+
+func (s *MemoryStore) GetDeadLetter(id int64) (*config.DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dl, ok := s.deadLetters[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	deadLetter := *dl
+	return &deadLetter, nil
+}
+
+func (s *MemoryStore) DeleteDeadLetter(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.deadLetters[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.deadLetters, id)
+	return nil
+}
+
+func (s *MemoryStore) PurgeDeadLetters() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := int64(len(s.deadLetters))
+	s.deadLetters = map[int64]*config.DeadLetter{}
+	return n, nil
+}
@@ -0,0 +1,269 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations are embedded per dialect as <version>_<name>.up.sql and <version>_<name>.down.sql
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// ErrNoMigrations is returned by MigrateDown when nothing has been applied
+var ErrNoMigrations = errors.New("no migration to roll back")
+
+// Migration is one versioned schema change
+type Migration struct {
+	Version int
+	Name    string
+	Up      string `json:"-"`
+	Down    string `json:"-"`
+}
+
+// MigrationStatus tells whether a migration has been applied
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrator is implemented by stores with a versioned schema
+type Migrator interface {
+	// MigrateUp applies every pending migration, oldest first, and returns them
+	MigrateUp() ([]Migration, error)
+	// MigrateDown rolls back the latest applied migration and returns it
+	MigrateDown() (*Migration, error)
+	MigrationStatus() ([]MigrationStatus, error)
+}
+
+// loadMigrations reads the migrations of a dialect, sorted by version.
+func loadMigrations(dir string) ([]Migration, error) {
+	dir = path.Join("migrations", dir)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading migrations: %w", err)
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		base, direction, ok := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), ".")
+		version, name, found := strings.Cut(base, "_")
+		v, err := strconv.Atoi(version)
+		if !ok || !found || err != nil || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("invalid migration file name %s, expected <version>_<name>.up.sql or .down.sql", entry.Name())
+		}
+		content, err := migrationFiles.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("reading migration %s: %w", entry.Name(), err)
+		}
+
+		m, exists := byVersion[v]
+		if !exists {
+			m = &Migration{Version: v, Name: name}
+			byVersion[v] = m
+		}
+		if direction == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// statements splits a script on the semicolons that end a line, since not every
+// driver runs several statements in one call.
+func statements(script string) []string {
+	var stmts []string
+	for _, part := range strings.SplitAfter(script, ";\n") {
+		if stmt := strings.TrimSpace(part); stmt != "" && stmt != ";" {
+			stmts = append(stmts, strings.TrimSuffix(stmt, ";"))
+		}
+	}
+	return stmts
+}
+
+func (s *sqlStore) appliedMigrations() (map[int]time.Time, error) {
+	if _, err := s.exec(s.dialect.migrationsTable); err != nil {
+		return nil, fmt.Errorf("creating migrations table: %w", err)
+	}
+	rows, err := s.query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("querying migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("scanning migration: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// runMigration executes a script and records the change in the same transaction.
+// MySQL commits DDL implicitly, so a failing migration there may be left half applied.
+func (s *sqlStore) runMigration(m Migration, script, record string, args ...interface{}) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range statements(script) {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+		}
+	}
+	if _, err := tx.Exec(s.rebind(record), args...); err != nil {
+		return fmt.Errorf("recording migration %d_%s: %w", m.Version, m.Name, err)
+	}
+	return tx.Commit()
+}
+
+// withMigrationLock runs fn holding the dialect's migration lock, so that instances starting
+// together don't apply the same migrations. The lock belongs to a session: it's taken on a
+// connection of its own, and the applied versions must be read once it's held.
+func (s *sqlStore) withMigrationLock(fn func() error) error {
+	if s.dialect.lockMigrations == "" {
+		return fn()
+	}
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("getting a connection for the migration lock: %w", err)
+	}
+	defer conn.Close()
+
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, s.dialect.lockMigrations).Scan(&locked); err != nil {
+		return fmt.Errorf("taking the migration lock: %w", err)
+	}
+	if locked.Int64 != 1 {
+		return errors.New("timed out waiting for the migration lock")
+	}
+	defer func() {
+		if _, err := conn.ExecContext(ctx, s.dialect.unlockMigrations); err != nil {
+			slog.Warn("Failed to release the migration lock, closing its connection", "error", err)
+			// The lock goes away with the session, don't give the connection back to the pool
+			conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+	}()
+	return fn()
+}
+
+func (s *sqlStore) MigrateUp() ([]Migration, error) {
+	migrations, err := loadMigrations(s.dialect.migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	err = s.withMigrationLock(func() error {
+		applied, err := s.appliedMigrations()
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			err := s.runMigration(m, m.Up, "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)", m.Version, m.Name, now())
+			if err != nil {
+				return err
+			}
+			done = append(done, m)
+		}
+		return nil
+	})
+	return done, err
+}
+
+func (s *sqlStore) MigrateDown() (*Migration, error) {
+	migrations, err := loadMigrations(s.dialect.migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	var rolledBack *Migration
+	err = s.withMigrationLock(func() error {
+		applied, err := s.appliedMigrations()
+		if err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			if m.Down == "" {
+				return fmt.Errorf("migration %d_%s can't be rolled back, it has no down script", m.Version, m.Name)
+			}
+			if err := s.runMigration(m, m.Down, "DELETE FROM schema_migrations WHERE version = ?", m.Version); err != nil {
+				return err
+			}
+			rolledBack = &m
+			return nil
+		}
+		return ErrNoMigrations
+	})
+	return rolledBack, err
+}
+
+func (s *sqlStore) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := loadMigrations(s.dialect.migrations)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		st := MigrationStatus{Migration: m}
+		if t, ok := applied[m.Version]; ok {
+			st.AppliedAt = &t
+		}
+		status = append(status, st)
+	}
+	return status, nil
+}
+
+// Migrate applies pending migrations when the store has a versioned schema.
+func Migrate(st Store) error {
+	migrator, ok := st.(Migrator)
+	if !ok {
+		return nil
+	}
+	applied, err := migrator.MigrateUp()
+	for _, m := range applied {
+		slog.Info("Applied migration", "version", fmt.Sprintf("%04d", m.Version), "name", m.Name)
+	}
+	return err
+}
@@ -1,3 +1,5 @@
+-- The scheduled_jobs table as the original db/init.sql created it, so that existing
+-- databases are picked up as they are and brought up to date by the later migrations
 CREATE TABLE IF NOT EXISTS scheduled_jobs (
     id INT AUTO_INCREMENT PRIMARY KEY,
     name VARCHAR(255) NOT NULL,
@@ -8,8 +10,3 @@ CREATE TABLE IF NOT EXISTS scheduled_jobs (
     last_run DATETIME,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
-
--- Insert dummy data (optional)
-INSERT INTO scheduled_jobs (name, notification_type, recipient, message, schedule_expression) VALUES
-('Daily Report', 'email', 'report@example.com', 'Daily report email', '0 0 * * *'),
-('Hourly Update', 'sms', '+15551234567', 'Hourly update SMS', '0 * * * *');
\ No newline at end of file
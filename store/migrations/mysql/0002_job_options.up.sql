@@ -0,0 +1,11 @@
+-- The original db/init.sql inserted sample jobs with plain-text messages, which can't be
+-- read as JSON. Turn them into text messages
+UPDATE scheduled_jobs SET message = JSON_OBJECT('message', message) WHERE message IS NOT NULL AND NOT JSON_VALID(message);
+ALTER TABLE scheduled_jobs
+    MODIFY schedule_expression VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN timezone VARCHAR(64) NOT NULL DEFAULT '',
+    ADD COLUMN send_at DATETIME NULL,
+    ADD COLUMN misfire_policy VARCHAR(16) NOT NULL DEFAULT '',
+    ADD COLUMN misfire_limit INT NOT NULL DEFAULT 0,
+    ADD COLUMN enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    ADD COLUMN completed_at DATETIME NULL;
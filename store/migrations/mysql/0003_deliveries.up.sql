@@ -0,0 +1,38 @@
+CREATE TABLE IF NOT EXISTS deliveries (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    job_id INT NULL,
+    notification_type VARCHAR(255) NOT NULL,
+    channel VARCHAR(255) NULL,
+    recipient VARCHAR(255) NOT NULL,
+    message TEXT,
+    catch_up BOOLEAN NOT NULL DEFAULT FALSE,
+    scheduled_for DATETIME NULL,
+    payload_hash CHAR(64) NOT NULL,
+    status VARCHAR(32) NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    response_code INT NULL,
+    last_error TEXT,
+    next_attempt_at DATETIME(6) NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_attempt_at TIMESTAMP NULL,
+    sent_at TIMESTAMP NULL,
+    claimed_by VARCHAR(255) NULL,
+    claimed_at DATETIME(6) NULL,
+    UNIQUE KEY uq_deliveries_run (job_id, scheduled_for),
+    INDEX idx_deliveries_status (status, id),
+    INDEX idx_deliveries_job (job_id),
+    INDEX idx_deliveries_created (created_at)
+);
+
+CREATE TABLE IF NOT EXISTS dead_letters (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    delivery_id BIGINT NOT NULL,
+    job_id INT NULL,
+    notification_type VARCHAR(255) NOT NULL,
+    recipient VARCHAR(255) NOT NULL,
+    message TEXT,
+    attempts INT NOT NULL,
+    last_error TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,3 @@
+ALTER TABLE dead_letters MODIFY recipient VARCHAR(255) NOT NULL;
+ALTER TABLE deliveries MODIFY recipient VARCHAR(255) NOT NULL;
+ALTER TABLE scheduled_jobs MODIFY recipient VARCHAR(255) NOT NULL;
@@ -0,0 +1,4 @@
+-- Recipient lists and web push subscriptions don't fit in 255 characters
+ALTER TABLE scheduled_jobs MODIFY recipient TEXT NOT NULL;
+ALTER TABLE deliveries MODIFY recipient TEXT NOT NULL;
+ALTER TABLE dead_letters MODIFY recipient TEXT NOT NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE deliveries ADD COLUMN completed TEXT NULL;
+ALTER TABLE dead_letters ADD COLUMN completed TEXT NULL;
@@ -0,0 +1,3 @@
+-- Set on every change to a job, so instances sharing the database can tell their live schedule is stale
+ALTER TABLE scheduled_jobs ADD COLUMN updated_at DATETIME(6) NULL;
+UPDATE scheduled_jobs SET updated_at = created_at;
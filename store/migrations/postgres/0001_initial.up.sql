@@ -0,0 +1,11 @@
+-- The scheduled_jobs table as the original db/init.sql created it, in PostgreSQL types
+CREATE TABLE IF NOT EXISTS scheduled_jobs (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    notification_type VARCHAR(255) NOT NULL,
+    recipient VARCHAR(255) NOT NULL,
+    message JSONB,
+    schedule_expression VARCHAR(255) NOT NULL,
+    last_run TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
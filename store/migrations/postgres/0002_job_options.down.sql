@@ -0,0 +1,8 @@
+ALTER TABLE scheduled_jobs
+    DROP COLUMN timezone,
+    DROP COLUMN send_at,
+    DROP COLUMN misfire_policy,
+    DROP COLUMN misfire_limit,
+    DROP COLUMN enabled,
+    DROP COLUMN completed_at,
+    ALTER COLUMN schedule_expression DROP DEFAULT;
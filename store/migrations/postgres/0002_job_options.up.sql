@@ -0,0 +1,8 @@
+ALTER TABLE scheduled_jobs
+    ALTER COLUMN schedule_expression SET DEFAULT '',
+    ADD COLUMN timezone VARCHAR(64) NOT NULL DEFAULT '',
+    ADD COLUMN send_at TIMESTAMPTZ NULL,
+    ADD COLUMN misfire_policy VARCHAR(16) NOT NULL DEFAULT '',
+    ADD COLUMN misfire_limit INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    ADD COLUMN completed_at TIMESTAMPTZ NULL;
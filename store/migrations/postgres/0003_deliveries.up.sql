@@ -0,0 +1,39 @@
+CREATE TABLE IF NOT EXISTS deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    job_id INTEGER NULL,
+    notification_type VARCHAR(255) NOT NULL,
+    channel VARCHAR(255) NULL,
+    recipient VARCHAR(255) NOT NULL,
+    message JSONB,
+    catch_up BOOLEAN NOT NULL DEFAULT FALSE,
+    scheduled_for TIMESTAMPTZ NULL,
+    payload_hash CHAR(64) NOT NULL,
+    status VARCHAR(32) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    response_code INTEGER NULL,
+    last_error TEXT,
+    next_attempt_at TIMESTAMPTZ NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_attempt_at TIMESTAMPTZ NULL,
+    sent_at TIMESTAMPTZ NULL,
+    claimed_by VARCHAR(255) NULL,
+    claimed_at TIMESTAMPTZ NULL,
+    CONSTRAINT uq_deliveries_run UNIQUE (job_id, scheduled_for)
+);
+
+CREATE INDEX IF NOT EXISTS idx_deliveries_status ON deliveries (status, id);
+CREATE INDEX IF NOT EXISTS idx_deliveries_job ON deliveries (job_id);
+CREATE INDEX IF NOT EXISTS idx_deliveries_created ON deliveries (created_at);
+
+CREATE TABLE IF NOT EXISTS dead_letters (
+    id BIGSERIAL PRIMARY KEY,
+    delivery_id BIGINT NOT NULL,
+    job_id INTEGER NULL,
+    notification_type VARCHAR(255) NOT NULL,
+    recipient VARCHAR(255) NOT NULL,
+    message JSONB,
+    attempts INTEGER NOT NULL,
+    last_error TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
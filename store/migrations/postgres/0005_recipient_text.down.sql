@@ -0,0 +1,3 @@
+ALTER TABLE dead_letters ALTER COLUMN recipient TYPE VARCHAR(255);
+ALTER TABLE deliveries ALTER COLUMN recipient TYPE VARCHAR(255);
+ALTER TABLE scheduled_jobs ALTER COLUMN recipient TYPE VARCHAR(255);
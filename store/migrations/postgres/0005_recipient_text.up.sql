@@ -0,0 +1,4 @@
+-- Recipient lists and web push subscriptions don't fit in 255 characters
+ALTER TABLE scheduled_jobs ALTER COLUMN recipient TYPE TEXT;
+ALTER TABLE deliveries ALTER COLUMN recipient TYPE TEXT;
+ALTER TABLE dead_letters ALTER COLUMN recipient TYPE TEXT;
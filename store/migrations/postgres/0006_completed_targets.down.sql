@@ -0,0 +1,2 @@
+ALTER TABLE dead_letters DROP COLUMN completed;
+ALTER TABLE deliveries DROP COLUMN completed;
@@ -0,0 +1,11 @@
+-- The scheduled_jobs table as the original db/init.sql created it, in SQLite types
+CREATE TABLE IF NOT EXISTS scheduled_jobs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    notification_type TEXT NOT NULL,
+    recipient TEXT NOT NULL,
+    message TEXT,
+    schedule_expression TEXT NOT NULL,
+    last_run DATETIME,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
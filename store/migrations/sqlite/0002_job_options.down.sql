@@ -0,0 +1,6 @@
+ALTER TABLE scheduled_jobs DROP COLUMN timezone;
+ALTER TABLE scheduled_jobs DROP COLUMN send_at;
+ALTER TABLE scheduled_jobs DROP COLUMN misfire_policy;
+ALTER TABLE scheduled_jobs DROP COLUMN misfire_limit;
+ALTER TABLE scheduled_jobs DROP COLUMN enabled;
+ALTER TABLE scheduled_jobs DROP COLUMN completed_at;
@@ -0,0 +1,7 @@
+-- SQLite can't change a column default, jobs are always inserted with an expression
+ALTER TABLE scheduled_jobs ADD COLUMN timezone TEXT NOT NULL DEFAULT '';
+ALTER TABLE scheduled_jobs ADD COLUMN send_at DATETIME NULL;
+ALTER TABLE scheduled_jobs ADD COLUMN misfire_policy TEXT NOT NULL DEFAULT '';
+ALTER TABLE scheduled_jobs ADD COLUMN misfire_limit INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE scheduled_jobs ADD COLUMN enabled BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE scheduled_jobs ADD COLUMN completed_at DATETIME NULL;
@@ -0,0 +1,39 @@
+CREATE TABLE IF NOT EXISTS deliveries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    job_id INTEGER NULL,
+    notification_type TEXT NOT NULL,
+    channel TEXT NULL,
+    recipient TEXT NOT NULL,
+    message TEXT,
+    catch_up BOOLEAN NOT NULL DEFAULT FALSE,
+    scheduled_for DATETIME NULL,
+    payload_hash TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    response_code INTEGER NULL,
+    last_error TEXT,
+    next_attempt_at DATETIME NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    last_attempt_at DATETIME NULL,
+    sent_at DATETIME NULL,
+    claimed_by TEXT NULL,
+    claimed_at DATETIME NULL,
+    UNIQUE (job_id, scheduled_for)
+);
+
+CREATE INDEX IF NOT EXISTS idx_deliveries_status ON deliveries (status, id);
+CREATE INDEX IF NOT EXISTS idx_deliveries_job ON deliveries (job_id);
+CREATE INDEX IF NOT EXISTS idx_deliveries_created ON deliveries (created_at);
+
+CREATE TABLE IF NOT EXISTS dead_letters (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    delivery_id INTEGER NOT NULL,
+    job_id INTEGER NULL,
+    notification_type TEXT NOT NULL,
+    recipient TEXT NOT NULL,
+    message TEXT,
+    attempts INTEGER NOT NULL,
+    last_error TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1 @@
+ALTER TABLE deliveries DROP COLUMN provider_message_id;
@@ -0,0 +1 @@
+ALTER TABLE deliveries ADD COLUMN provider_message_id TEXT NULL;
@@ -0,0 +1,42 @@
+package store
+
+import (
+	"database/sql"
+	"dynamic-notification-system/config"
+	"errors"
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// errDuplicateEntry is MySQL's ER_DUP_ENTRY
+const errDuplicateEntry = 1062
+
+func openMySQL(cfg config.DatabaseConfig) (Store, error) {
+	// Construct DB connection string. clientFoundRows makes updates that change nothing
+	// still report the matched row, so they aren't mistaken for a missing one.
+	dbConnStr := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true&clientFoundRows=true",
+		cfg.User,
+		cfg.Password,
+		cfg.Host,
+		cfg.Port,
+		cfg.Name,
+	)
+
+	db, err := sql.Open("mysql", dbConnStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to DB: %w", err)
+	}
+	return &sqlStore{db: db, dialect: dialect{
+		isDuplicate:      isMySQLDuplicate,
+		migrations:       "mysql",
+		migrationsTable:  "CREATE TABLE IF NOT EXISTS schema_migrations (version INT PRIMARY KEY, name VARCHAR(255) NOT NULL, applied_at DATETIME NOT NULL)",
+		lockMigrations:   "SELECT GET_LOCK('schema_migrations', 600)",
+		unlockMigrations: "SELECT RELEASE_LOCK('schema_migrations')",
+	}}, nil
+}
+
+func isMySQLDuplicate(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == errDuplicateEntry
+}
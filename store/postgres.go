@@ -0,0 +1,51 @@
+package store
+
+import (
+	"database/sql"
+	"dynamic-notification-system/config"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// uniqueViolation is PostgreSQL's unique_violation SQLSTATE
+const uniqueViolation = "23505"
+
+// openPostgres connects to PostgreSQL. Messages are stored as JSONB and every
+// timestamp as timestamptz.
+func openPostgres(cfg config.DatabaseConfig) (Store, error) {
+	port := cfg.Port
+	if port == 0 {
+		port = 5432
+	}
+	// Built as a URL so credentials with special characters are escaped
+	dsn := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(cfg.User, cfg.Password),
+		Host:   cfg.Host + ":" + strconv.Itoa(port),
+		Path:   "/" + cfg.Name,
+	}
+
+	db, err := sql.Open("pgx", dsn.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to DB: %w", err)
+	}
+	return &sqlStore{db: db, dialect: dialect{
+		numberedParams:   true,
+		returningID:      true,
+		isDuplicate:      isPostgresDuplicate,
+		migrations:       "postgres",
+		migrationsTable:  "CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, name VARCHAR(255) NOT NULL, applied_at TIMESTAMPTZ NOT NULL)",
+		lockMigrations:   "SELECT 1 FROM pg_advisory_lock(hashtext('schema_migrations'))",
+		unlockMigrations: "SELECT pg_advisory_unlock(hashtext('schema_migrations'))",
+	}}, nil
+}
+
+func isPostgresDuplicate(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation
+}
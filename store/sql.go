@@ -0,0 +1,499 @@
+package store
+
+import (
+	"database/sql"
+	"dynamic-notification-system/config"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const jobColumns = "id, name, notification_type, recipient, message, schedule_expression, timezone, send_at, misfire_policy, misfire_limit, enabled, last_run, completed_at, updated_at"
+
+const deliveryColumns = "id, job_id, notification_type, COALESCE(channel, ''), recipient, message, catch_up, scheduled_for, payload_hash, status, attempts, response_code, COALESCE(last_error, ''), COALESCE(provider_message_id, ''), completed, created_at, updated_at, last_attempt_at, sent_at"
+
+const deadLetterColumns = "id, delivery_id, job_id, notification_type, recipient, message, attempts, last_error, completed, created_at"
+
+// dialect holds what differs between the SQL databases
+type dialect struct {
+	// numberedParams rewrites ? placeholders to $1, $2...
+	numberedParams bool
+	// returningID reads generated IDs with RETURNING instead of LastInsertId
+	returningID bool
+	// isDuplicate reports whether err is a unique constraint violation
+	isDuplicate func(err error) bool
+	// migrations is the directory holding the dialect's migrations
+	migrations string
+	// migrationsTable creates the table recording applied migrations
+	migrationsTable string
+	// lockMigrations waits for the lock serializing migrations across instances and returns 1
+	// once it's held, unlockMigrations releases it. Both are empty when there's no such lock
+	lockMigrations   string
+	unlockMigrations string
+}
+
+// sqlStore implements Store on top of database/sql. Queries are written with ? placeholders
+// and rebound for the dialect. Timestamps are always passed in from Go, in UTC, so every
+// database compares them the same way.
+type sqlStore struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *sqlStore) rebind(query string) string {
+	if !s.dialect.numberedParams {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (s *sqlStore) exec(query string, args ...interface{}) (sql.Result, error) {
+	return s.db.Exec(s.rebind(query), args...)
+}
+
+func (s *sqlStore) queryRow(query string, args ...interface{}) *sql.Row {
+	return s.db.QueryRow(s.rebind(query), args...)
+}
+
+func (s *sqlStore) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return s.db.Query(s.rebind(query), args...)
+}
+
+// insert runs an INSERT and returns the generated id.
+func (s *sqlStore) insert(tx *sql.Tx, query string, args ...interface{}) (int64, error) {
+	query = s.rebind(query)
+	if s.dialect.returningID {
+		var id int64
+		var err error
+		if tx != nil {
+			err = tx.QueryRow(query+" RETURNING id", args...).Scan(&id)
+		} else {
+			err = s.db.QueryRow(query+" RETURNING id", args...).Scan(&id)
+		}
+		return id, err
+	}
+
+	var result sql.Result
+	var err error
+	if tx != nil {
+		result, err = tx.Exec(query, args...)
+	} else {
+		result, err = s.db.Exec(query, args...)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func now() time.Time {
+	return time.Now().UTC()
+}
+
+// jobUpdatedAt returns the version stamp of a job change, in the precision every database keeps
+func jobUpdatedAt() time.Time {
+	return now().Truncate(time.Microsecond)
+}
+
+// affected turns "no row changed" into ErrNotFound
+func affected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Jobs
+
+func scanJob(row scanner) (*config.ScheduledJob, error) {
+	var job config.ScheduledJob
+	err := row.Scan(&job.ID, &job.Name, &job.NotificationType, &job.Recipient, &job.Message, &job.ScheduleExpression, &job.Timezone, &job.SendAt,
+		&job.MisfirePolicy, &job.MisfireLimit, &job.Enabled, &job.LastRun, &job.CompletedAt, &job.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (s *sqlStore) ListJobs() ([]config.ScheduledJob, error) {
+	rows, err := s.query("SELECT " + jobColumns + " FROM scheduled_jobs ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("querying jobs: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := []config.ScheduledJob{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning job: %w", err)
+		}
+		jobs = append(jobs, *job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return jobs, nil
+}
+
+func (s *sqlStore) GetJob(id int) (*config.ScheduledJob, error) {
+	job, err := scanJob(s.queryRow("SELECT "+jobColumns+" FROM scheduled_jobs WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading job %d: %w", id, err)
+	}
+	return job, nil
+}
+
+func (s *sqlStore) CreateJob(job *config.ScheduledJob) error {
+	t := jobUpdatedAt()
+	id, err := s.insert(nil, "INSERT INTO scheduled_jobs (name, notification_type, recipient, message, schedule_expression, timezone, send_at, misfire_policy, misfire_limit, enabled, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		job.Name, job.NotificationType, job.Recipient, job.Message, job.ScheduleExpression, job.Timezone, utc(job.SendAt), job.MisfirePolicy, job.MisfireLimit, job.Enabled, t, t)
+	if err != nil {
+		return fmt.Errorf("inserting job: %w", err)
+	}
+	job.ID = int(id)
+	job.UpdatedAt = &t
+	return nil
+}
+
+func (s *sqlStore) UpdateJob(job *config.ScheduledJob) error {
+	t := jobUpdatedAt()
+	result, err := s.exec("UPDATE scheduled_jobs SET name = ?, notification_type = ?, recipient = ?, message = ?, schedule_expression = ?, timezone = ?, send_at = ?, misfire_policy = ?, misfire_limit = ?, enabled = ?, completed_at = ?, updated_at = ? WHERE id = ?",
+		job.Name, job.NotificationType, job.Recipient, job.Message, job.ScheduleExpression, job.Timezone, utc(job.SendAt), job.MisfirePolicy, job.MisfireLimit, job.Enabled, utc(job.CompletedAt), t, job.ID)
+	if err != nil {
+		return fmt.Errorf("updating job %d: %w", job.ID, err)
+	}
+	if err := affected(result); err != nil {
+		return err
+	}
+	job.UpdatedAt = &t
+	return nil
+}
+
+func (s *sqlStore) DeleteJob(id int) error {
+	result, err := s.exec("DELETE FROM scheduled_jobs WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("deleting job %d: %w", id, err)
+	}
+	return affected(result)
+}
+
+func (s *sqlStore) SetJobEnabled(id int, enabled bool) error {
+	result, err := s.exec("UPDATE scheduled_jobs SET enabled = ?, updated_at = ? WHERE id = ?", enabled, jobUpdatedAt(), id)
+	if err != nil {
+		return fmt.Errorf("updating job %d: %w", id, err)
+	}
+	return affected(result)
+}
+
+func (s *sqlStore) CompleteJob(id int) error {
+	_, err := s.exec("UPDATE scheduled_jobs SET completed_at = ?, updated_at = ? WHERE id = ?", now(), jobUpdatedAt(), id)
+	if err != nil {
+		return fmt.Errorf("completing job %d: %w", id, err)
+	}
+	return nil
+}
+
+func (s *sqlStore) LastFiredAt(id int) (time.Time, error) {
+	var lastRun, lastDelivery sql.NullTime
+	var createdAt time.Time
+	err := s.queryRow("SELECT last_run, created_at FROM scheduled_jobs WHERE id = ?", id).Scan(&lastRun, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, ErrNotFound
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("loading job %d: %w", id, err)
+	}
+	err = s.queryRow("SELECT created_at FROM deliveries WHERE job_id = ? ORDER BY created_at DESC LIMIT 1", id).Scan(&lastDelivery)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, fmt.Errorf("loading deliveries of job %d: %w", id, err)
+	}
+
+	since := createdAt
+	if lastRun.Valid {
+		since = lastRun.Time
+	}
+	if lastDelivery.Valid && lastDelivery.Time.After(since) {
+		since = lastDelivery.Time
+	}
+	return since, nil
+}
+
+// Deliveries
+
+func scanDelivery(row scanner) (*config.Delivery, error) {
+	var d config.Delivery
+	err := row.Scan(&d.ID, &d.JobID, &d.NotificationType, &d.Channel, &d.Recipient, &d.Message, &d.CatchUp, &d.ScheduledFor, &d.PayloadHash, &d.Status,
+		&d.Attempts, &d.ResponseCode, &d.LastError, &d.ProviderMessageID, &d.Completed, &d.CreatedAt, &d.UpdatedAt, &d.LastAttemptAt, &d.SentAt)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (s *sqlStore) InsertDelivery(d *config.Delivery) error {
+	t := now()
+	// The unique (job_id, scheduled_for) key lets a single instance claim each scheduled run
+	id, err := s.insert(nil, "INSERT INTO deliveries (job_id, notification_type, recipient, message, catch_up, scheduled_for, payload_hash, status, completed, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		d.JobID, d.NotificationType, d.Recipient, d.Message, d.CatchUp, utc(d.ScheduledFor), d.PayloadHash, StatusPending, d.Completed, t, t)
+	if err != nil && s.dialect.isDuplicate(err) {
+		return ErrDuplicateRun
+	}
+	if err != nil {
+		return fmt.Errorf("inserting delivery: %w", err)
+	}
+	d.ID = id
+	d.Status = StatusPending
+	d.CreatedAt = t
+	d.UpdatedAt = t
+	return nil
+}
+
+func (s *sqlStore) ClaimDelivery(instanceID string) (*config.Delivery, error) {
+	for {
+		var id int64
+		err := s.queryRow("SELECT id FROM deliveries WHERE status = ? AND (next_attempt_at IS NULL OR next_attempt_at <= ?) ORDER BY id LIMIT 1",
+			StatusPending, now()).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("selecting pending delivery: %w", err)
+		}
+
+		// Another worker may have claimed the row in the meantime
+		t := now()
+		result, err := s.exec("UPDATE deliveries SET status = ?, claimed_by = ?, claimed_at = ?, updated_at = ? WHERE id = ? AND status = ?",
+			StatusProcessing, instanceID, t, t, id, StatusPending)
+		if err != nil {
+			return nil, fmt.Errorf("claiming delivery %d: %w", id, err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			continue
+		}
+		return s.GetDelivery(id)
+	}
+}
+
+func (s *sqlStore) GetDelivery(id int64) (*config.Delivery, error) {
+	d, err := scanDelivery(s.queryRow("SELECT "+deliveryColumns+" FROM deliveries WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading delivery %d: %w", id, err)
+	}
+	return d, nil
+}
+
+func (s *sqlStore) ListDeliveries(filter DeliveryFilter) ([]config.Delivery, error) {
+	query := "SELECT " + deliveryColumns + " FROM deliveries WHERE 1 = 1"
+	var args []interface{}
+	if filter.Status != "" {
+		query += " AND status = ?"
+		args = append(args, filter.Status)
+	}
+	if filter.Channel != "" {
+		query += " AND (notification_type = ? OR channel = ?)"
+		args = append(args, filter.Channel, filter.Channel)
+	}
+	if filter.JobID != nil {
+		query += " AND job_id = ?"
+		args = append(args, *filter.JobID)
+	}
+	if filter.From != nil {
+		query += " AND created_at >= ?"
+		args = append(args, filter.From.UTC())
+	}
+	if filter.To != nil {
+		query += " AND created_at < ?"
+		args = append(args, filter.To.UTC())
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, filter.Limit)
+
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []config.Delivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning delivery: %w", err)
+		}
+		deliveries = append(deliveries, *d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return deliveries, nil
+}
+
+func (s *sqlStore) MarkSent(d *config.Delivery) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	t := now()
+	_, err = tx.Exec(s.rebind("UPDATE deliveries SET status = ?, channel = ?, attempts = ?, response_code = NULL, last_error = ?, provider_message_id = ?, completed = ?, last_attempt_at = ?, sent_at = ?, updated_at = ? WHERE id = ?"),
+		StatusSent, d.Channel, d.Attempts, d.LastError, d.ProviderMessageID, d.Completed, t, t, t, d.ID)
+	if err != nil {
+		return fmt.Errorf("updating delivery %d: %w", d.ID, err)
+	}
+	if d.JobID != nil {
+		_, err = tx.Exec(s.rebind("UPDATE scheduled_jobs SET last_run = ? WHERE id = ?"), t, *d.JobID)
+		if err != nil {
+			return fmt.Errorf("updating last_run: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) ScheduleRetry(d *config.Delivery, nextAttempt time.Time) error {
+	t := now()
+	_, err := s.exec("UPDATE deliveries SET status = ?, channel = ?, attempts = ?, response_code = ?, last_error = ?, provider_message_id = ?, completed = ?, next_attempt_at = ?, last_attempt_at = ?, updated_at = ? WHERE id = ?",
+		StatusPending, d.Channel, d.Attempts, d.ResponseCode, d.LastError, d.ProviderMessageID, d.Completed, nextAttempt.UTC(), t, t, d.ID)
+	if err != nil {
+		return fmt.Errorf("updating delivery %d: %w", d.ID, err)
+	}
+	return nil
+}
+
+func (s *sqlStore) MarkDead(d *config.Delivery) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	t := now()
+	_, err = tx.Exec(s.rebind("UPDATE deliveries SET status = ?, channel = ?, attempts = ?, response_code = ?, last_error = ?, provider_message_id = ?, completed = ?, last_attempt_at = ?, updated_at = ? WHERE id = ?"),
+		StatusFailed, d.Channel, d.Attempts, d.ResponseCode, d.LastError, d.ProviderMessageID, d.Completed, t, t, d.ID)
+	if err != nil {
+		return fmt.Errorf("updating delivery %d: %w", d.ID, err)
+	}
+	_, err = s.insert(tx, "INSERT INTO dead_letters (delivery_id, job_id, notification_type, recipient, message, attempts, last_error, completed, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		d.ID, d.JobID, d.NotificationType, d.Recipient, d.Message, d.Attempts, d.LastError, d.Completed, t)
+	if err != nil {
+		return fmt.Errorf("inserting dead letter for delivery %d: %w", d.ID, err)
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) RequeueClaimedBy(instanceID string) (int64, error) {
+	result, err := s.exec("UPDATE deliveries SET status = ?, updated_at = ? WHERE status = ? AND claimed_by = ?",
+		StatusPending, now(), StatusProcessing, instanceID)
+	if err != nil {
+		return 0, fmt.Errorf("requeueing interrupted deliveries: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+func (s *sqlStore) RequeueClaimedBefore(t time.Time) (int64, error) {
+	result, err := s.exec("UPDATE deliveries SET status = ?, updated_at = ? WHERE status = ? AND claimed_at < ?",
+		StatusPending, now(), StatusProcessing, t.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("requeueing stale deliveries: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// Dead letters
+
+func scanDeadLetter(row scanner) (*config.DeadLetter, error) {
+	var dl config.DeadLetter
+	err := row.Scan(&dl.ID, &dl.DeliveryID, &dl.JobID, &dl.NotificationType, &dl.Recipient, &dl.Message, &dl.Attempts, &dl.LastError, &dl.Completed, &dl.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &dl, nil
+}
+
+func (s *sqlStore) ListDeadLetters() ([]config.DeadLetter, error) {
+	rows, err := s.query("SELECT " + deadLetterColumns + " FROM dead_letters ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("querying dead letters: %w", err)
+	}
+	defer rows.Close()
+
+	deadLetters := []config.DeadLetter{}
+	for rows.Next() {
+		dl, err := scanDeadLetter(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning dead letter: %w", err)
+		}
+		deadLetters = append(deadLetters, *dl)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return deadLetters, nil
+}
+
+func (s *sqlStore) GetDeadLetter(id int64) (*config.DeadLetter, error) {
+	dl, err := scanDeadLetter(s.queryRow("SELECT "+deadLetterColumns+" FROM dead_letters WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading dead letter %d: %w", id, err)
+	}
+	return dl, nil
+}
+
+func (s *sqlStore) DeleteDeadLetter(id int64) error {
+	result, err := s.exec("DELETE FROM dead_letters WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("deleting dead letter %d: %w", id, err)
+	}
+	return affected(result)
+}
+
+func (s *sqlStore) PurgeDeadLetters() (int64, error) {
+	result, err := s.exec("DELETE FROM dead_letters")
+	if err != nil {
+		return 0, fmt.Errorf("purging dead letters: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// utc converts optional timestamps before they are written
+func utc(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
@@ -0,0 +1,39 @@
+package store
+
+import (
+	"database/sql"
+	"dynamic-notification-system/config"
+	"errors"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// defaultSQLitePath is used when database.path is not set
+const defaultSQLitePath = "notifications.db"
+
+// openSQLite opens the database file, which is created if needed, so a single
+// binary can run without any external database.
+func openSQLite(cfg config.DatabaseConfig) (Store, error) {
+	path := cfg.Path
+	if path == "" {
+		path = defaultSQLitePath
+	}
+
+	// Writers wait for each other instead of failing with "database is locked"
+	db, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=5000&_foreign_keys=on")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database %s: %w", path, err)
+	}
+	db.SetMaxOpenConns(1)
+	return &sqlStore{db: db, dialect: dialect{
+		isDuplicate:     isSQLiteDuplicate,
+		migrations:      "sqlite",
+		migrationsTable: "CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, name TEXT NOT NULL, applied_at DATETIME NOT NULL)",
+	}}, nil
+}
+
+func isSQLiteDuplicate(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
@@ -0,0 +1,100 @@
+package store
+
+import (
+	"dynamic-notification-system/config"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Delivery statuses
+const (
+	StatusPending    = "pending"
+	StatusProcessing = "processing"
+	StatusSent       = "sent"
+	StatusFailed     = "failed"
+)
+
+// ErrNotFound is returned when a job, delivery or dead letter doesn't exist
+var ErrNotFound = errors.New("not found")
+
+// ErrDuplicateRun is returned by InsertDelivery when the same scheduled run was already queued,
+// typically by another instance sharing the database
+var ErrDuplicateRun = errors.New("scheduled run already queued")
+
+// DeliveryFilter narrows the deliveries returned by ListDeliveries
+type DeliveryFilter struct {
+	Status  string
+	Channel string // Matches the requested notification type or the channel that handled it
+	JobID   *int
+	From    *time.Time
+	To      *time.Time
+	Limit   int
+}
+
+// JobStore persists scheduled jobs
+type JobStore interface {
+	ListJobs() ([]config.ScheduledJob, error)
+	GetJob(id int) (*config.ScheduledJob, error)
+	// CreateJob inserts the job and sets its ID
+	CreateJob(job *config.ScheduledJob) error
+	// UpdateJob writes every field of the job but its last run
+	UpdateJob(job *config.ScheduledJob) error
+	DeleteJob(id int) error
+	SetJobEnabled(id int, enabled bool) error
+	// CompleteJob records that a one-shot job has fired
+	CompleteJob(id int) error
+	// LastFiredAt returns when the job last fired: the later of its last run and its most
+	// recent delivery, which may still be pending. Jobs that never fired use their creation time.
+	LastFiredAt(id int) (time.Time, error)
+}
+
+// DeliveryStore persists the delivery queue, the delivery history and the dead letters
+type DeliveryStore interface {
+	// InsertDelivery queues the delivery and sets its ID and status. Only one delivery
+	// may exist per job and scheduled time, others fail with ErrDuplicateRun.
+	InsertDelivery(d *config.Delivery) error
+	// ClaimDelivery marks the oldest due delivery as processing by the instance and returns it,
+	// or nil when nothing is due
+	ClaimDelivery(instanceID string) (*config.Delivery, error)
+	GetDelivery(id int64) (*config.Delivery, error)
+	ListDeliveries(filter DeliveryFilter) ([]config.Delivery, error)
+	// MarkSent records a successful delivery and, for scheduled jobs, the job's last run
+	MarkSent(d *config.Delivery) error
+	// ScheduleRetry puts a failed delivery back in the queue until nextAttempt
+	ScheduleRetry(d *config.Delivery, nextAttempt time.Time) error
+	// MarkDead fails a delivery for good and copies it to the dead letters
+	MarkDead(d *config.Delivery) error
+	// RequeueClaimedBy puts the deliveries an instance was processing back in the queue
+	RequeueClaimedBy(instanceID string) (int64, error)
+	// RequeueClaimedBefore puts deliveries claimed before t back in the queue
+	RequeueClaimedBefore(t time.Time) (int64, error)
+
+	ListDeadLetters() ([]config.DeadLetter, error)
+	GetDeadLetter(id int64) (*config.DeadLetter, error)
+	DeleteDeadLetter(id int64) error
+	PurgeDeadLetters() (int64, error)
+}
+
+// Store is the persistence backend of the scheduler and the delivery queue
+type Store interface {
+	JobStore
+	DeliveryStore
+	Close() error
+}
+
+// Open returns the backend selected by the database.driver setting
+func Open(cfg config.DatabaseConfig) (Store, error) {
+	switch cfg.Driver {
+	case "", "mysql":
+		return openMySQL(cfg)
+	case "postgres":
+		return openPostgres(cfg)
+	case "sqlite":
+		return openSQLite(cfg)
+	case "memory":
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown database driver %q, expected mysql, postgres, sqlite or memory", cfg.Driver)
+	}
+}